@@ -0,0 +1,138 @@
+package varchive
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// -autoFixAudio: rather than repairing the audio of every file, look for the signs of
+// trouble and only schedule a FixAudio for the files that show them. The signs are
+//
+//   - audio and video that last noticeably different lengths
+//   - audio codecs that are known to upset HandBrake or the mp4 muxer (the odd PCM
+//     variants found in MPEG program streams, mostly)
+//   - timestamp discontinuities and decode errors when ffmpeg reads the audio through
+//
+// -fixAudio still forces a repair of every file
+
+// these get demuxed badly, or not at all
+var suspectAudioCodecs = map[string]bool{
+	"pcm_dvd":       true,
+	"pcm_bluray":    true,
+	"pcm_s16be":     true,
+	"pcm_s20be":     true,
+	"pcm_s24be":     true,
+	"pcm_u8":        true,
+	"adpcm_ms":      true,
+	"adpcm_ima_wav": true,
+}
+
+// anything less than this is just the usual difference in frame lengths
+const durationMismatchSeconds = 0.5
+
+// what ffmpeg says when the timestamps jump about
+var discontinuityMarkers = []string{"monoton", "discontinuity", "invalid dts", "invalid pts", "timestamp"}
+
+// reading the audio through, and nothing else
+func audioScanArgs(path string) []string {
+	return []string{
+		"-hide_banner", "-nostats",
+		"-v", "warning",
+		"-i", path,
+		"-map", "0:a?",
+		"-f", "null",
+		"-"}
+}
+
+// DiagnoseAudio lists what looks wrong with the audio, given the ffprobe results and the
+// warnings ffmpeg gave while reading the audio through. Nothing wrong is an empty list
+func DiagnoseAudio(media MediaInfo, scanOutput string) []string {
+	problems := []string{}
+
+	audio := media.StreamsOfType("audio")
+	if len(audio) == 0 {
+		return problems
+	}
+
+	for _, stream := range audio {
+		if suspectAudioCodecs[stream.CodecName] || stream.CodecName == "" {
+			problems = append(problems, fmt.Sprintf("unsupported audio codec '%s'", stream.CodecName))
+		}
+	}
+
+	if video := media.VideoStream(); video != nil && video.Duration > 0 && audio[0].Duration > 0 {
+		difference := audio[0].Duration - video.Duration
+		allowed := math.Max(durationMismatchSeconds, video.Duration/100)
+		if math.Abs(difference) > allowed {
+			problems = append(problems, fmt.Sprintf("audio lasts %.2fs, video %.2fs", audio[0].Duration, video.Duration))
+		}
+	}
+
+	discontinuities := 0
+	errors := 0
+	for _, line := range strings.Split(scanOutput, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if containsAny(line, discontinuityMarkers) {
+			discontinuities++
+		} else if strings.Contains(line, "error") || strings.Contains(line, "invalid") || strings.Contains(line, "corrupt") {
+			errors++
+		}
+	}
+	if discontinuities > 0 {
+		problems = append(problems, fmt.Sprintf("%d timestamp discontinuities", discontinuities))
+	}
+	if errors > 0 {
+		problems = append(problems, fmt.Sprintf("%d decode errors", errors))
+	}
+
+	return problems
+}
+
+func containsAny(text string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if strings.Contains(text, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func diagnoseAudioFor(file *FileWithSize) []string {
+	media, err := ProbeMedia(file.path)
+	if err != nil {
+		Log("Could not check the audio of %s: %s", file.path, err.Error())
+		return []string{}
+	}
+	if len(media.StreamsOfType("audio")) == 0 {
+		return []string{}
+	}
+
+	// ffmpeg only fails outright if it can't read the file at all, otherwise it
+	// carries on and complains on stderr
+	_, stderr, err := probe(settings.ffmpegPath, audioScanArgs(file.path))
+	if err != nil {
+		Log("Could not check the audio of %s: %s", file.path, err.Error())
+		return []string{}
+	}
+	return DiagnoseAudio(media, stderr)
+}
+
+// reading the audio through takes a while, so several files are done at once
+func diagnoseAudio(files []*FileWithSize) map[*FileWithSize][]string {
+	diagnoses := make(map[*FileWithSize][]string)
+	lock := sync.Mutex{}
+
+	forEachFileInParallel(files, func(file *FileWithSize) {
+		problems := diagnoseAudioFor(file)
+		lock.Lock()
+		diagnoses[file] = problems
+		lock.Unlock()
+	})
+
+	return diagnoses
+}
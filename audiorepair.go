@@ -0,0 +1,159 @@
+package varchive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// how FixAudio re-encodes the audio: which codec, what sample rate, whether to correct
+// drift, shift or pad the audio, and whether to normalise its loudness.
+// The defaults (mp3, as is, cut to the shorter of the audio and the video) are what
+// FixAudio always did
+
+type audioCodec struct {
+	encoder   string // as ffmpeg knows it
+	extension string // for a temporary file holding just the one stream
+}
+
+var audioCodecs = map[string]audioCodec{
+	"mp3":  {"mp3", ".mp3"},
+	"aac":  {"aac", ".m4a"},
+	"opus": {"libopus", ".opus"},
+	"flac": {"flac", ".flac"},
+}
+
+var audioCodecNames = []string{"mp3", "aac", "opus", "flac"}
+
+// EBU R128, more or less the usual target for speech
+const loudnormTarget = "I=-16:TP=-1.5:LRA=11"
+
+// loudnorm works at 192kHz internally, so there has to be an output rate
+const loudnormSampleRate = 48000
+
+func isKnownAudioCodec(name string) bool {
+	_, found := audioCodecs[name]
+	return found
+}
+
+// anything other than mp3 won't go in an MPEG program stream (or an AVI...) so
+// the repaired file becomes a Matroska file, which HandBrake is happy to read.
+// The same goes for any subtitles that have to come along
+func fixAudioOutputExtension(sourcePath string) string {
+	if settings.audioCodec == "mp3" && !carryingSubtitles() {
+		return getFileExtension(sourcePath)
+	}
+	return ".mkv"
+}
+
+// the figures from the first pass of loudnorm, which are fed into the second
+type Loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// loudnorm prints its measurements as a JSON block at the end of ffmpeg's (stderr) output
+func ParseLoudnormOutput(output string) (*Loudness, error) {
+	end := strings.LastIndex(output, "}")
+	if end < 0 {
+		return nil, errors.New("no loudnorm measurements in the output")
+	}
+	start := strings.LastIndex(output[:end], "{")
+	if start < 0 {
+		return nil, errors.New("no loudnorm measurements in the output")
+	}
+
+	loudness := &Loudness{}
+	if err := json.Unmarshal([]byte(output[start:end+1]), loudness); err != nil {
+		return nil, fmt.Errorf("could not read the loudnorm measurements: %s", err.Error())
+	}
+	if loudness.InputI == "" || loudness.InputI == "-inf" {
+		return nil, errors.New("the audio is silent")
+	}
+	return loudness, nil
+}
+
+// the first pass: measure, but write nothing. If several audio streams are kept they all
+// get the correction measured from the first of them
+func loudnormMeasureArgs(task *Task) []string {
+	args := []string{"-hide_banner", "-nostats", "-i", task.fileIn}
+	args = append(args, task.streams.audioMaps(0)[:2]...)
+	return append(args,
+		"-af", "loudnorm="+loudnormTarget+":print_format=json",
+		"-f", "null",
+		"-")
+}
+
+func measureLoudness(task *Task) (*Loudness, error) {
+	_, stderr, err := probe(settings.ffmpegPath, loudnormMeasureArgs(task))
+	if err != nil {
+		return nil, err
+	}
+	return ParseLoudnormOutput(stderr)
+}
+
+// how long the video in the source lasts, or 0 if we can't tell
+func videoDuration(task *Task) float64 {
+	if task.source == nil {
+		return 0
+	}
+	media, err := ProbeMedia(task.source.path)
+	if err != nil {
+		return 0
+	}
+	if video := media.VideoStream(); video != nil && video.Duration > 0 {
+		return video.Duration
+	}
+	return media.Duration
+}
+
+// the -af filter chain for the audio re-encode, empty if there is nothing to do.
+// With no measurements, loudnorm falls back to its (less accurate) single pass mode
+func audioRepairFilters(loudness *Loudness, padTo float64) string {
+	filters := []string{}
+
+	if settings.audioResync {
+		// stretch or squeeze the audio (by up to 1000 samples a second) to match its timestamps
+		filters = append(filters, "aresample=async=1000:first_pts=0")
+	}
+
+	if settings.loudnorm {
+		if loudness == nil {
+			filters = append(filters, "loudnorm="+loudnormTarget)
+		} else {
+			filters = append(filters, fmt.Sprintf(
+				"loudnorm=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+				loudnormTarget, loudness.InputI, loudness.InputTP, loudness.InputLRA, loudness.InputThresh, loudness.TargetOffset))
+		}
+	}
+
+	if padTo > 0 {
+		filters = append(filters, fmt.Sprintf("apad=whole_dur=%.3f", padTo))
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// -ar for the audio re-encode, if any
+func audioRepairSampleRate() []string {
+	rate := settings.audioSampleRate
+	if rate == 0 && settings.loudnorm {
+		rate = loudnormSampleRate
+	}
+	if rate == 0 {
+		return []string{}
+	}
+	return []string{"-ar", fmt.Sprintf("%d", rate)}
+}
+
+// positive offsets delay the audio, negative ones bring it forward
+func audioOffsetArgs() []string {
+	if settings.audioOffset == 0 {
+		return []string{}
+	}
+	return []string{"-itsoffset", fmt.Sprintf("%.3f", settings.audioOffset.Seconds())}
+}
@@ -0,0 +1,272 @@
+package varchive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// the catalogue remembers which sources (by content hash) went into which archive, with which
+// profile and settings, so a rerun over the same directories can skip the groups that have not
+// changed. Changing anything that shapes the archive (see settingsFingerprint) means a rebuild.
+//
+// It is a plain JSON file (by default in the output root). It also remembers the size and
+// modification time of each file it has hashed, so unchanged files are not hashed again.
+
+type Catalogue struct {
+	lock sync.Mutex
+	path string
+
+	Files    map[string]*CataloguedFile    `json:"files"`    // by source path
+	Sources  map[string]*CataloguedSource  `json:"sources"`  // by source sha256
+	Archives map[string]*CataloguedArchive `json:"archives"` // by intended output path
+}
+
+type CataloguedFile struct {
+	Size     int64  `json:"size"`
+	ModTime  string `json:"modTime"`
+	Checksum string `json:"sha256"`
+}
+
+type CataloguedSource struct {
+	Path    string `json:"path"`
+	Archive string `json:"archive"`
+	Profile string `json:"profile"`
+}
+
+type CataloguedArchive struct {
+	Sources  []string `json:"sources"` // sha256 of each source, in order
+	Profile  string   `json:"profile"`
+	Settings string   `json:"settings"` // see settingsFingerprint, empty in older catalogues
+
+	// where it was actually written, if something else was in the way (-onCollision rename)
+	RenamedTo string `json:"renamedTo,omitempty"`
+}
+
+// where the archive is on disk
+func (a *CataloguedArchive) path(intended string) string {
+	if a.RenamedTo != "" {
+		return a.RenamedTo
+	}
+	return intended
+}
+
+type CatalogueDecision int
+
+const (
+	NotCatalogued CatalogueDecision = 0 // never made this output, so whatever is there is not ours
+	Unchanged     CatalogueDecision = 1 // made it, from the same sources with the same settings
+	Changed       CatalogueDecision = 2 // made it, but something is different now
+)
+
+var catalogue *Catalogue
+
+func cataloguePath() string {
+	if settings.cataloguePath != "" {
+		return settings.cataloguePath
+	}
+	return filepath.Join(settings.outputRoot, "varchive.catalogue.json")
+}
+
+func OpenCatalogue(path string) (*Catalogue, error) {
+	c := &Catalogue{
+		path:     path,
+		Files:    make(map[string]*CataloguedFile),
+		Sources:  make(map[string]*CataloguedSource),
+		Archives: make(map[string]*CataloguedArchive),
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return c, nil
+}
+
+func (c *Catalogue) checksumFor(file *FileWithSize) (string, error) {
+	modTime := file.modTime.Format("2006-01-02T15:04:05.999999999Z07:00")
+
+	c.lock.Lock()
+	known, found := c.Files[file.path]
+	c.lock.Unlock()
+
+	if found && known.Size == file.size && known.ModTime == modTime {
+		return known.Checksum, nil
+	}
+
+	checksum, err := sourceChecksum(file)
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	c.Files[file.path] = &CataloguedFile{file.size, modTime, checksum}
+	c.lock.Unlock()
+
+	return checksum, nil
+}
+
+func (c *Catalogue) checksumsFor(group *Group) ([]string, error) {
+	checksums := []string{}
+	for _, file := range group.files {
+		checksum, err := c.checksumFor(file)
+		if err != nil {
+			return nil, err
+		}
+		checksums = append(checksums, checksum)
+	}
+	return checksums, nil
+}
+
+// archives are looked up by where they were meant to go, so one that had to be renamed
+// is still found on the next run
+func (c *Catalogue) Check(group *Group) CatalogueDecision {
+	output := group.intendedOutputPath()
+
+	c.lock.Lock()
+	archive, found := c.Archives[output]
+	c.lock.Unlock()
+
+	if !found {
+		return NotCatalogued
+	}
+	output = archive.path(output)
+
+	checksums, err := c.checksumsFor(group)
+	if err != nil {
+		Log("Could not checksum the sources for %s: %s", output, err.Error())
+		return Changed
+	}
+
+	if _, err := os.Stat(output); err != nil {
+		return Changed
+	}
+	if archive.Profile != group.profile.name || len(archive.Sources) != len(checksums) {
+		return Changed
+	}
+	// older catalogues only have the profile name to go on
+	if archive.Settings != "" && archive.Settings != SettingsFingerprint(group) {
+		return Changed
+	}
+	for index, checksum := range checksums {
+		if archive.Sources[index] != checksum {
+			return Changed
+		}
+	}
+	return Unchanged
+}
+
+// points the group at the archive we made for it last time, renamed or not, so that a rebuild
+// replaces it rather than making yet another
+func (c *Catalogue) RestoreOutput(group *Group) {
+	c.lock.Lock()
+	archive, found := c.Archives[group.intendedOutputPath()]
+	c.lock.Unlock()
+
+	if found && archive.RenamedTo != "" {
+		group.output = archive.RenamedTo
+	}
+}
+
+// any sources that have already gone into a different archive are worth a mention
+func (c *Catalogue) ReportSourcesArchivedElsewhere(group *Group) {
+	output := group.OutputPath()
+	for _, file := range group.files {
+		checksum, err := c.checksumFor(file)
+		if err != nil {
+			continue
+		}
+		c.lock.Lock()
+		source, found := c.Sources[checksum]
+		c.lock.Unlock()
+		if found && source.Archive != output {
+			Log("%s was already archived (as %s) in %s with profile %s", file.path, source.Path, source.Archive, source.Profile)
+		}
+	}
+}
+
+func (c *Catalogue) Record(group *Group) error {
+	checksums, err := c.checksumsFor(group)
+	if err != nil {
+		return err
+	}
+
+	output := group.OutputPath()
+	renamedTo := ""
+	if output != group.intendedOutputPath() {
+		renamedTo = output
+	}
+
+	c.lock.Lock()
+	c.Archives[group.intendedOutputPath()] = &CataloguedArchive{checksums, group.profile.name, SettingsFingerprint(group), renamedTo}
+	for index, checksum := range checksums {
+		c.Sources[checksum] = &CataloguedSource{group.files[index].path, output, group.profile.name}
+	}
+	c.lock.Unlock()
+
+	return c.Save()
+}
+
+// everything other than the sources that shapes the archive (the picture, the sound, the
+// subtitles, the chapters and the tags) in a form that is easy to compare
+// (and to read, when wondering why something was rebuilt)
+func SettingsFingerprint(group *Group) string {
+	profile := group.profile
+	parts := []string{
+		"profile=" + profile.String(),
+		fmt.Sprintf("geometry=%sx%s@%s", profile.width, profile.height, profile.fps),
+		"filters=" + group.FilterChain().String(),
+		"transcoder=" + settings.transcoder,
+		"deinterlace=" + settings.deinterlace + ":" + settings.deinterlacer,
+		"autoCrop=" + settings.autoCrop,
+		"subtitles=" + settings.subtitles + ":" + settings.subtitleFormat,
+		"audioTracks=" + settings.audioTracks + ":" + settings.audioLanguage,
+		fmt.Sprintf("fixAudio=%t:%t:%s:%s:%d:%t:%s:%s:%t", settings.fixAudio, settings.autoFixAudio, settings.fixAudioMode,
+			settings.audioCodec, settings.audioSampleRate, settings.audioResync, settings.audioOffset, settings.audioFit, settings.loudnorm),
+		"order=" + settings.order,
+		fmt.Sprintf("chapters=%t", settings.chapters),
+		fmt.Sprintf("tags=%t:%t:%q", settings.writeTags, settings.preserveSourceTags, []string(settings.tags)),
+		fmt.Sprintf("describedAs=%q", []string{group.title, group.date, group.description}),
+	}
+
+	for index, file := range group.files {
+		if settings.chapters && file.chapter != "" {
+			parts = append(parts, fmt.Sprintf("chapter%d=%q", index+1, file.chapter))
+		}
+		if segments := segmentsFor(file); segments != nil {
+			trims := []string{}
+			for _, segment := range segments {
+				trims = append(trims, segment.String())
+			}
+			parts = append(parts, fmt.Sprintf("trim%d=%s", index+1, strings.Join(trims, ",")))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// writes to a temporary file first, so a crash can't leave half a catalogue behind
+func (c *Catalogue) Save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := c.path + ".tmp"
+	if err := os.WriteFile(temporary, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, c.path)
+}
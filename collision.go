@@ -0,0 +1,145 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// what to do when an output file already exists (settings.onCollision):
+//
+//    fail                - refuse to start, listing every output that is in the way
+//    skip                - leave the existing output alone, and don't build that group
+//    overwrite           - replace it
+//    rename              - write to name-1.mp4 (or name-2.mp4, ...) instead
+//    overwrite-if-older  - replace it if any of the sources is newer than it, otherwise skip
+//
+// This is all decided before any task is generated. Outputs the catalogue knows we made are
+// always replaceable, and are never considered a collision (the catalogue remembers a renamed
+// output under the name it was meant to have, so a rerun replaces it rather than renaming again).
+
+var collisionPolicies = []string{"fail", "skip", "overwrite", "rename", "overwrite-if-older"}
+
+// in dry run mode the outputs that would stop a real run are listed in the plan instead
+var outputsInTheWay = []string{}
+
+// what to do about an output that is in the way
+type CollisionDecision int
+
+const (
+	RefuseToStart       CollisionDecision = 0
+	SkipGroup           CollisionDecision = 1
+	OverwriteOutput     CollisionDecision = 2
+	RenameOutput        CollisionDecision = 3
+	SkipGroupAsUpToDate CollisionDecision = 4 // overwrite-if-older, and it isn't
+)
+
+// DecideCollision applies the policy to an existing output, last modified at outputModTime,
+// whose newest source was modified at newestSource
+func DecideCollision(policy string, outputModTime Timestamp, newestSource Timestamp) CollisionDecision {
+	switch policy {
+	case "skip":
+		return SkipGroup
+	case "overwrite":
+		return OverwriteOutput
+	case "rename":
+		return RenameOutput
+	case "overwrite-if-older":
+		if newestSource.After(outputModTime) {
+			return OverwriteOutput
+		}
+		return SkipGroupAsUpToDate
+	}
+	return RefuseToStart
+}
+
+func resolveOutputCollisions(groups []*Group) []*Group {
+	result := []*Group{}
+	inTheWay := []string{}
+	claimed := make(map[string]bool)
+
+	for _, group := range groups {
+		claimed[group.OutputPath()] = true
+	}
+
+	for _, group := range groups {
+		output := group.OutputPath()
+		existing, err := os.Stat(output)
+
+		if err != nil || group.overwrite {
+			result = append(result, group)
+			continue
+		}
+
+		switch DecideCollision(settings.onCollision, existing.ModTime(), newestSource(group)) {
+		case RefuseToStart:
+			inTheWay = append(inTheWay, output)
+
+		case SkipGroup:
+			Log("Skipping %s, it already exists", output)
+
+		case OverwriteOutput:
+			if settings.onCollision == "overwrite-if-older" {
+				Log("%s is older than its sources and will be overwritten", output)
+			} else {
+				Log("%s exists and will be overwritten", output)
+			}
+			group.overwrite = true
+			result = append(result, group)
+
+		case RenameOutput:
+			group.output = FirstFreeOutputPath(output, claimed)
+			claimed[group.output] = true
+			Log("%s exists, writing to %s instead", output, group.output)
+			result = append(result, group)
+
+		case SkipGroupAsUpToDate:
+			Log("Skipping %s, it is newer than all of its sources", output)
+		}
+	}
+
+	if len(inTheWay) > 0 && settings.dryRun {
+		outputsInTheWay = inTheWay
+	} else if len(inTheWay) > 0 {
+		fatal(fmt.Sprintf("%d output(s) exist, will not overwrite (see -onCollision):\n  %s",
+			len(inTheWay), strings.Join(inTheWay, "\n  ")))
+	}
+
+	return result
+}
+
+// FirstFreeOutputPath finds the first of name-1.mp4, name-2.mp4... that neither exists nor
+// has been claimed by another group
+func FirstFreeOutputPath(output string, claimed map[string]bool) string {
+	extension := getFileExtension(output)
+	stem := strings.TrimSuffix(output, extension)
+
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, n, extension)
+		if _, err := os.Stat(candidate); err != nil && !claimed[candidate] {
+			return candidate
+		}
+	}
+}
+
+func newestSource(group *Group) Timestamp {
+	newest := Timestamp{}
+	for _, file := range group.files {
+		if file.modTime.After(newest) {
+			newest = file.modTime
+		}
+	}
+	return newest
+}
+
+// outputs are written under a temporary name, and only take their real name once complete,
+// so a failed or interrupted run never leaves something that looks like a finished archive
+func partialOutputPath(path string) string {
+	return path + ".partial"
+}
+
+func promotePartialOutput(path string) {
+	if err := os.Rename(partialOutputPath(path), path); err != nil {
+		fatal(fmt.Sprintf("Could not rename %s to %s: %s", partialOutputPath(path), path, err.Error()))
+	}
+}
@@ -0,0 +1,223 @@
+package varchive
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// -autoCrop: left to itself HandBrake picks a crop for every clip separately, so the parts
+// of a group can come out at different sizes, which concatenation doesn't like. Instead we
+// can run ffmpeg's cropdetect over each source and tell HandBrake exactly what to crop:
+//
+//   handbrake  leave it to HandBrake (as varchive always did)
+//   none       don't crop at all
+//   file       crop each source by what cropdetect found in it
+//   group      crop every source in a group by the same amount, the least found in any of them
+//
+// Crops are only ever as big as the borders in every frame sampled, so a frame with
+// something in the border keeps it
+
+var autoCropModes = []string{"handbrake", "none", "file", "group"}
+
+func detectingCrops() bool {
+	return settings.autoCrop == "file" || settings.autoCrop == "group"
+}
+
+// pixels to take off each edge
+type Crop struct {
+	Top    int64
+	Bottom int64
+	Left   int64
+	Right  int64
+}
+
+// the form HandBrake's --crop wants
+func (c *Crop) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", c.Top, c.Bottom, c.Left, c.Right)
+}
+
+func (c *Crop) ffmpegFilter() string {
+	return fmt.Sprintf("crop=iw-%d:ih-%d:%d:%d", c.Left+c.Right, c.Top+c.Bottom, c.Left, c.Top)
+}
+
+// the smaller crop on every edge, so that nothing either would keep gets cut
+func (c *Crop) union(other *Crop) *Crop {
+	return &Crop{
+		minInt64(c.Top, other.Top),
+		minInt64(c.Bottom, other.Bottom),
+		minInt64(c.Left, other.Left),
+		minInt64(c.Right, other.Right)}
+}
+
+func minInt64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var cropDetectPattern = regexp.MustCompile(`x1:(-?\d+) x2:(-?\d+) y1:(-?\d+) y2:(-?\d+)`)
+
+// ParseCropDetect works out the crop from cropdetect's output for a width x height video:
+// the picture is taken to be everything any of the sampled frames had in it.
+// Frames that were entirely black (which cropdetect reports inside out) are ignored
+func ParseCropDetect(output string, width int64, height int64) (*Crop, error) {
+	left, right, top, bottom := width, int64(-1), height, int64(-1)
+
+	for _, match := range cropDetectPattern.FindAllStringSubmatch(output, -1) {
+		x1, _ := strconv.ParseInt(match[1], 10, 64)
+		x2, _ := strconv.ParseInt(match[2], 10, 64)
+		y1, _ := strconv.ParseInt(match[3], 10, 64)
+		y2, _ := strconv.ParseInt(match[4], 10, 64)
+		if x2 < x1 || y2 < y1 {
+			continue
+		}
+		left, right = minInt64(left, x1), maxInt64(right, x2)
+		top, bottom = minInt64(top, y1), maxInt64(bottom, y2)
+	}
+
+	if right < 0 || bottom < 0 {
+		return nil, fmt.Errorf("cropdetect found no picture")
+	}
+
+	// even numbers only, rounding towards cropping less
+	crop := &Crop{top, height - 1 - bottom, left, width - 1 - right}
+	crop.Top -= crop.Top % 2
+	crop.Bottom -= crop.Bottom % 2
+	crop.Left -= crop.Left % 2
+	crop.Right -= crop.Right % 2
+	return crop, nil
+}
+
+// keyframes only, across the whole file, which is quick and plenty of samples.
+// reset=1 makes cropdetect report on each frame on its own, rather than cumulatively
+func cropDetectArgs(path string) []string {
+	return []string{
+		"-hide_banner", "-nostats",
+		"-skip_frame", "nokey",
+		"-i", path,
+		"-map", "0:v:0",
+		"-vf", "cropdetect=limit=24:round=2:reset=1",
+		"-an",
+		"-f", "null",
+		"-"}
+}
+
+func detectCropFor(file *FileWithSize) (*Crop, error) {
+	media, err := ProbeMedia(file.path)
+	if err != nil {
+		return nil, err
+	}
+	video := media.VideoStream()
+	if video == nil {
+		return nil, fmt.Errorf("no video stream")
+	}
+
+	// cropdetect reports on stderr
+	_, stderr, err := probe(settings.ffmpegPath, cropDetectArgs(file.path))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCropDetect(stderr, video.Width, video.Height)
+}
+
+// cropdetect samples several points of each source, so a source's crop is worked out
+// once and kept by path. A group crop (the union of its members' crops, when they are
+// all the same size) is kept too, rather than rebuilt for every part. A source we could
+// not check is not cropped
+var crops = make(map[string]*Crop)
+var groupCrops = make(map[*Group]*Crop)
+var cropLock sync.Mutex
+
+func detectCrops(files []*FileWithSize) {
+	forEachFileInParallel(files, func(file *FileWithSize) {
+		cropOf(file)
+	})
+}
+
+func cropOf(file *FileWithSize) *Crop {
+	cropLock.Lock()
+	crop, found := crops[file.path]
+	cropLock.Unlock()
+	if found {
+		return crop
+	}
+
+	crop, err := detectCropFor(file)
+	if err != nil {
+		Log("Could not detect the borders of %s, so it won't be cropped: %s", file.path, err.Error())
+		crop = &Crop{}
+	}
+
+	cropLock.Lock()
+	crops[file.path] = crop
+	cropLock.Unlock()
+	return crop
+}
+
+// only sources of the same size can share a crop, otherwise each gets its own
+func groupCropOf(group *Group) *Crop {
+	cropLock.Lock()
+	crop, found := groupCrops[group]
+	cropLock.Unlock()
+	if found {
+		return crop
+	}
+
+	geometries := make(map[string]bool)
+	for _, file := range group.files {
+		if media, err := ProbeMedia(file.path); err == nil {
+			if info, err := media.VideoInfo(); err == nil {
+				geometries[fmt.Sprintf("%dx%d", info.Width, info.Height)] = true
+			}
+		}
+	}
+
+	if len(geometries) == 1 {
+		for _, file := range group.files {
+			if crop == nil {
+				crop = cropOf(file)
+			} else {
+				crop = crop.union(cropOf(file))
+			}
+		}
+	} else {
+		Log("The sources for %s are not all the same size, so they will be cropped one by one", group.OutputPath())
+	}
+
+	cropLock.Lock()
+	groupCrops[group] = crop
+	cropLock.Unlock()
+	return crop
+}
+
+// what to tell HandBrake to crop from this file, or nil to let it decide for itself
+func cropFor(file *FileWithSize, group *Group) *Crop {
+	switch settings.autoCrop {
+	case "none":
+		return &Crop{}
+	case "file":
+		if file != nil {
+			return cropOf(file)
+		}
+	case "group":
+		if group != nil {
+			if crop := groupCropOf(group); crop != nil {
+				return crop
+			}
+		}
+		if file != nil {
+			return cropOf(file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package varchive
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// -deinterlace auto decides, file by file, whether the source is interlaced. ffprobe's
+// field_order is often missing (or wrong) for MPEG-2 and DV, so a sample of frames is run
+// through ffmpeg's idet filter as well, and what idet sees wins over what the file claims
+
+var deinterlaceModes = []string{"auto", "always", "never"}
+
+var deinterlacers = []string{"decomb", "yadif", "bwdif", "bob"}
+
+// frames to run through idet, a little way into the file (to skip any titles)
+const idetFrames = 500
+
+type Interlacing struct {
+	Interlaced bool
+	Reason     string
+}
+
+func (i *Interlacing) String() string {
+	if i.Interlaced {
+		return "yes (" + i.Reason + ")"
+	}
+	return "no (" + i.Reason + ")"
+}
+
+var idetPattern = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)`)
+
+// DecideInterlacing makes up its mind from the field order (as ffprobe reports it) and the
+// output of the idet filter, either of which may be missing
+func DecideInterlacing(fieldOrder string, idetOutput string) *Interlacing {
+	if match := idetPattern.FindStringSubmatch(idetOutput); match != nil {
+		tff, _ := strconv.Atoi(match[1])
+		bff, _ := strconv.Atoi(match[2])
+		progressive, _ := strconv.Atoi(match[3])
+		if tff+bff+progressive > 0 {
+			return &Interlacing{
+				tff+bff > progressive,
+				fmt.Sprintf("idet: %d TFF, %d BFF, %d progressive", tff, bff, progressive)}
+		}
+	}
+
+	switch fieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return &Interlacing{true, "field order " + fieldOrder}
+	case "progressive":
+		return &Interlacing{false, "field order progressive"}
+	}
+	return &Interlacing{false, "could not tell"}
+}
+
+func idetArgs(path string, duration float64) []string {
+	args := []string{"-hide_banner", "-nostats"}
+	if duration > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", duration/4))
+	}
+	return append(args,
+		"-i", path,
+		"-map", "0:v:0",
+		"-vf", "idet",
+		"-frames:v", fmt.Sprintf("%d", idetFrames),
+		"-an",
+		"-f", "null",
+		"-")
+}
+
+func detectInterlacingFor(file *FileWithSize) *Interlacing {
+	media, err := ProbeMedia(file.path)
+	if err != nil {
+		return &Interlacing{false, "could not probe it"}
+	}
+	video := media.VideoStream()
+	if video == nil {
+		return &Interlacing{false, "no video stream"}
+	}
+
+	// idet reports on stderr
+	_, stderr, err := probe(settings.ffmpegPath, idetArgs(file.path, media.Duration))
+	if err != nil && settings.verbose {
+		Log("Could not sample %s for interlacing: %s", file.path, err.Error())
+	}
+	return DecideInterlacing(video.FieldOrder, stderr)
+}
+
+// idet decodes a few hundred frames, so each source is sampled once, up front, and
+// the decision is kept by path for as long as we run
+var interlacingDecisions = make(map[string]*Interlacing)
+var interlacingLock sync.Mutex
+
+func detectInterlacing(files []*FileWithSize) {
+	forEachFileInParallel(files, func(file *FileWithSize) {
+		interlacingOf(file)
+	})
+}
+
+func interlacingOf(file *FileWithSize) *Interlacing {
+	interlacingLock.Lock()
+	decision, found := interlacingDecisions[file.path]
+	interlacingLock.Unlock()
+	if found {
+		return decision
+	}
+
+	decision = detectInterlacingFor(file)
+
+	interlacingLock.Lock()
+	interlacingDecisions[file.path] = decision
+	interlacingLock.Unlock()
+	return decision
+}
+
+// whether the Transcode of this file should deinterlace
+func shouldDeinterlace(file *FileWithSize) bool {
+	switch settings.deinterlace {
+	case "always":
+		return true
+	case "auto":
+		return file != nil && interlacingOf(file).Interlaced
+	}
+	return false
+}
+
+// the nearest ffmpeg has to each of HandBrake's deinterlacers
+func ffmpegDeinterlaceFilter(deinterlacer string) string {
+	switch deinterlacer {
+	case "yadif":
+		return "yadif"
+	case "bwdif":
+		return "bwdif"
+	case "bob":
+		return "yadif=mode=send_field"
+	}
+	// like decomb, only touch the frames that look interlaced, and keep the frame rate
+	return "yadif=mode=send_frame:deint=interlaced"
+}
+
+// HandBrake's flags for each deinterlacer. 'bob' gives one frame per field (double rate)
+func deinterlacerArgs(deinterlacer string) []string {
+	switch deinterlacer {
+	case "yadif":
+		return []string{"--deinterlace=default"}
+	case "bwdif":
+		return []string{"--bwdif=default"}
+	case "bob":
+		return []string{"--deinterlace=bob"}
+	}
+	return []string{"--comb-detect=default", "--decomb=eedi2bob"}
+}
@@ -0,0 +1,400 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// before any task runs we check that the tools are there, and that they can do what this
+// run is going to ask of them (the encoders and filters), rather than finding out from a
+// fatal() half way through. 'varchive doctor' prints the whole story:
+//
+//    varchive doctor [-ffmpeg path] [-profile name] [-manifest file] [other options]
+//
+// Only ffmpeg's filters can be listed, so HandBrake's filters are taken on trust
+
+type Capabilities struct {
+	locations         map[string]string // by tool name, empty if it could not be found
+	ffmpegEncoders    map[string]bool
+	ffmpegFilters     map[string]bool
+	handbrakeEncoders map[string]bool
+}
+
+// a missing tool gives empty lists, which the problems will point out
+func ProbeCapabilities() *Capabilities {
+	capabilities := &Capabilities{
+		locations: make(map[string]string),
+	}
+
+	for name, path := range toolPaths() {
+		if location, err := exec.LookPath(path); err == nil {
+			capabilities.locations[name] = location
+		}
+	}
+
+	stdout, _, _ := probe(settings.ffmpegPath, []string{"-hide_banner", "-encoders"})
+	capabilities.ffmpegEncoders = ParseFfmpegList(stdout)
+
+	stdout, _, _ = probe(settings.ffmpegPath, []string{"-hide_banner", "-filters"})
+	capabilities.ffmpegFilters = ParseFfmpegList(stdout)
+
+	// HandBrake writes its help to stdout or stderr, depending on the version
+	stdout, stderr, _ := probe(settings.handbrakePath, []string{"--help"})
+	capabilities.handbrakeEncoders = ParseHandBrakeEncoders(stdout + "\n" + stderr)
+
+	return capabilities
+}
+
+func toolPaths() map[string]string {
+	return map[string]string{
+		"ffmpeg":       settings.ffmpegPath,
+		"ffprobe":      settings.ffprobePath,
+		"HandBrakeCLI": settings.handbrakePath,
+	}
+}
+
+// -reportSizes, -inventory and -suggestTrims only look at the sources
+func transcoding() bool {
+	return !settings.reportSizes && settings.inventory == "" && !settings.suggestTrims
+}
+
+// the tools this run can't do without
+func requiredTools() []string {
+	tools := []string{"ffmpeg", "ffprobe"}
+	if settings.transcoder == "handbrake" && transcoding() {
+		tools = append(tools, "HandBrakeCLI")
+	}
+	return tools
+}
+
+var ffmpegListFlags = regexp.MustCompile(`^[A-Z.|]{3,6}$`)
+
+// ParseFfmpegList picks the names out of 'ffmpeg -encoders' or 'ffmpeg -filters', e.g.
+//
+//	V....D libx265              libx265 H.265 / HEVC (codec hevc)
+//	... hqdn3d            V->V       Apply a High Quality 3D Denoiser.
+//
+// skipping the legend at the top
+func ParseFfmpegList(output string) map[string]bool {
+	names := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] == "=" || !ffmpegListFlags.MatchString(fields[0]) {
+			continue
+		}
+		names[fields[1]] = true
+	}
+	return names
+}
+
+// ParseHandBrakeEncoders picks the video encoders out of 'HandBrakeCLI --help', which
+// lists them one per line after the --encoder option
+func ParseHandBrakeEncoders(help string) map[string]bool {
+	encoders := make(map[string]bool)
+	listing := false
+	for _, line := range strings.Split(help, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.Contains(line, "--encoder <"):
+			listing = true
+		case listing && len(fields) == 1 && !strings.HasPrefix(fields[0], "-") && !strings.HasSuffix(fields[0], ":"):
+			encoders[fields[0]] = true
+		case listing:
+			if len(encoders) > 0 {
+				return encoders
+			}
+		}
+	}
+	return encoders
+}
+
+// ffmpeg will use whichever of these it has for "-codec mp3"
+var mp3Encoders = []string{"libmp3lame", "libshine", "mp3_mf"}
+
+type requirement struct {
+	name string
+	why  string
+}
+
+func requiredFfmpegEncoders(profiles []*Profile) []requirement {
+	required := []requirement{}
+	if !transcoding() {
+		return required
+	}
+
+	if settings.transcoder == "ffmpeg" {
+		for _, profile := range profiles {
+			encoder, found := ffmpegEncoders[profile.encoder]
+			if !found {
+				encoder = profile.encoder
+			}
+			required = append(required, requirement{encoder, "profile " + profile.name})
+		}
+	}
+
+	if settings.fixAudio || settings.autoFixAudio {
+		required = append(required, requirement{audioCodecs[settings.audioCodec].encoder, "-audioCodec " + settings.audioCodec})
+	}
+
+	if settings.subtitles == "extract" {
+		required = append(required, requirement{"srt", "-subtitles extract"})
+	}
+	if settings.subtitles == "keep" && settings.transcoder == "ffmpeg" {
+		required = append(required, requirement{"mov_text", "-subtitles keep"})
+	}
+
+	return required
+}
+
+func requiredFfmpegFilters(chains []FilterChain) []requirement {
+	required := []requirement{}
+	add := func(name string, why string) {
+		required = append(required, requirement{name, why})
+	}
+
+	if transcoding() && (settings.fixAudio || settings.autoFixAudio) {
+		if settings.loudnorm {
+			add("loudnorm", "-loudnorm")
+		}
+		if settings.audioResync {
+			add("aresample", "-audioResync")
+		}
+		if settings.audioFit == "pad" {
+			add("apad", "-audioFit pad")
+		}
+	}
+	if settings.deinterlace == "auto" {
+		add("idet", "-deinterlace auto")
+	}
+	if detectingCrops() {
+		add("cropdetect", "-autoCrop "+settings.autoCrop)
+	}
+	if settings.suggestTrims {
+		add("blackdetect", "-suggestTrims")
+		add("silencedetect", "-suggestTrims")
+	}
+
+	if transcoding() && settings.subtitles == "extract" {
+		add("movie", "-subtitles extract (for closed captions)")
+	}
+
+	if transcoding() && settings.transcoder == "ffmpeg" {
+		if settings.subtitles == "burn" {
+			add("overlay", "-subtitles burn (bitmap subtitles)")
+			add("subtitles", "-subtitles burn (text subtitles, needs libass)")
+		}
+		if settings.deinterlace != "never" {
+			add(strings.SplitN(ffmpegDeinterlaceFilter(settings.deinterlacer), "=", 2)[0], "-deinterlacer "+settings.deinterlacer)
+		}
+		for _, chain := range chains {
+			for _, filter := range chain.FfmpegFilters() {
+				for _, part := range strings.Split(filter, ",") {
+					add(strings.SplitN(part, "=", 2)[0], "the filters "+chain.String())
+				}
+			}
+		}
+	}
+
+	return required
+}
+
+// everything that stands in the way of running with these profiles and filter chains
+func (c *Capabilities) Problems(profiles []*Profile, chains []FilterChain) []string {
+	return append(c.MissingTools(), c.Unsupported(profiles, chains)...)
+}
+
+// the tools this run can't do without, that aren't there. Known before the sources are scanned
+func (c *Capabilities) MissingTools() []string {
+	problems := []string{}
+	for _, tool := range requiredTools() {
+		if c.locations[tool] == "" {
+			problems = append(problems, fmt.Sprintf("%s (%s) could not be found", tool, toolPaths()[tool]))
+		}
+	}
+	return problems
+}
+
+// the encoders and filters the profiles and filter chains ask for, that the tools don't have
+func (c *Capabilities) Unsupported(profiles []*Profile, chains []FilterChain) []string {
+	problems := []string{}
+
+	if settings.transcoder == "handbrake" && transcoding() && c.locations["HandBrakeCLI"] != "" {
+		for _, profile := range profiles {
+			if !c.handbrakeEncoders[profile.encoder] {
+				problems = append(problems, fmt.Sprintf("HandBrakeCLI has no %s encoder (needed by profile %s)", profile.encoder, profile.name))
+			}
+		}
+	}
+
+	if c.locations["ffmpeg"] != "" {
+		for _, encoder := range requiredFfmpegEncoders(profiles) {
+			if !c.hasFfmpegEncoder(encoder.name) {
+				problems = append(problems, fmt.Sprintf("ffmpeg has no %s encoder (needed by %s)", encoder.name, encoder.why))
+			}
+		}
+		for _, filter := range requiredFfmpegFilters(chains) {
+			if !c.ffmpegFilters[filter.name] {
+				problems = append(problems, fmt.Sprintf("ffmpeg has no %s filter (needed by %s)", filter.name, filter.why))
+			}
+		}
+	}
+
+	return uniqueStrings(problems)
+}
+
+func (c *Capabilities) hasFfmpegEncoder(name string) bool {
+	if name == "mp3" {
+		for _, encoder := range mp3Encoders {
+			if c.ffmpegEncoders[encoder] {
+				return true
+			}
+		}
+		return false
+	}
+	return c.ffmpegEncoders[name]
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (c *Capabilities) Report(profiles []*Profile, chains []FilterChain) string {
+	var b strings.Builder
+
+	versions := getToolVersions()
+	b.WriteString("Tools:\n")
+	for _, tool := range []string{"ffmpeg", "ffprobe", "HandBrakeCLI"} {
+		location := c.locations[tool]
+		if location == "" {
+			location = "(not found: " + toolPaths()[tool] + ")"
+		}
+		fmt.Fprintf(&b, "  %-13s %s\n", tool, location)
+		if c.locations[tool] != "" {
+			fmt.Fprintf(&b, "  %-13s %s\n", "", versions[tool])
+		}
+	}
+
+	fmt.Fprintf(&b, "\nHandBrakeCLI encoders: %s\n", sortedKeys(c.handbrakeEncoders))
+	fmt.Fprintf(&b, "ffmpeg: %d encoders, %d filters\n", len(c.ffmpegEncoders), len(c.ffmpegFilters))
+
+	b.WriteString("\nProfiles:\n")
+	for _, profile := range profiles {
+		fmt.Fprintf(&b, "  %v\n", profile)
+	}
+	if len(chains) > 0 {
+		b.WriteString("Filters:\n")
+		for _, chain := range chains {
+			fmt.Fprintf(&b, "  %s\n", chain)
+		}
+	}
+
+	problems := c.Problems(profiles, chains)
+	if len(problems) == 0 {
+		b.WriteString("\nNo problems found\n")
+	} else {
+		b.WriteString("\nProblems:\n")
+		for _, problem := range problems {
+			fmt.Fprintf(&b, "  - %s\n", problem)
+		}
+	}
+
+	return b.String()
+}
+
+func sortedKeys(set map[string]bool) string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return "(none)"
+	}
+	return strings.Join(keys, ", ")
+}
+
+// the profiles and filter chains of these groups, each just the once
+func profilesAndChainsOf(groups []*Group) ([]*Profile, []FilterChain) {
+	profiles := []*Profile{}
+	chains := []FilterChain{}
+	seen := make(map[string]bool)
+
+	for _, group := range groups {
+		if !seen["profile "+group.profile.name] {
+			seen["profile "+group.profile.name] = true
+			profiles = append(profiles, group.profile)
+		}
+		chain := group.FilterChain()
+		if len(chain) > 0 && !seen["chain "+chain.String()] {
+			seen["chain "+chain.String()] = true
+			chains = append(chains, chain)
+		}
+	}
+	return profiles, chains
+}
+
+// 'varchive doctor' checks the profile named on the command line, and those in the
+// manifest (if any), without scanning any paths
+func Doctor() {
+	profile, err := lookupProfile("")
+	if err != nil {
+		fatal(err.Error())
+	}
+	groups := []*Group{{profile: profile}}
+	if settings.manifest != "" {
+		manifestGroups, err := ReadManifest(settings.manifest)
+		if err != nil {
+			fatal(err.Error())
+		}
+		groups = append(groups, manifestGroups...)
+	}
+	profiles, chains := profilesAndChainsOf(groups)
+
+	capabilities := ProbeCapabilities()
+	fmt.Printf("varchive %s\n\n%s", Version, capabilities.Report(profiles, chains))
+
+	if len(capabilities.Problems(profiles, chains)) > 0 {
+		os.Exit(1)
+	}
+}
+
+// the startup checks, in two halves. The tools are checked before the sources are scanned,
+// since the scan needs ffprobe. Missing tools are always fatal; a dry run could get no further
+func checkTools() *Capabilities {
+	capabilities := ProbeCapabilities()
+	if problems := capabilities.MissingTools(); len(problems) > 0 {
+		fatal(cannotGoAhead(problems))
+	}
+	return capabilities
+}
+
+// then, once the groups are known, their profiles and filter chains. In dry run mode
+// these problems are just reported, otherwise they are fatal
+func checkCapabilities(capabilities *Capabilities, groups []*Group) {
+	problems := capabilities.Unsupported(profilesAndChainsOf(groups))
+	if len(problems) == 0 {
+		return
+	}
+
+	if settings.dryRun {
+		Log(cannotGoAhead(problems))
+		return
+	}
+	fatal(cannotGoAhead(problems))
+}
+
+func cannotGoAhead(problems []string) string {
+	return "Cannot go ahead:\n  - " + strings.Join(problems, "\n  - ") + "\n(run 'varchive doctor' for the details)"
+}
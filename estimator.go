@@ -8,15 +8,35 @@ type Estimator struct {
 	totalInputSize          [TaskTypeCount]float64 // cumulative for all completed tasks of this type
 	totalRunTime            [TaskTypeCount]float64 // ditto
 	estimatedBytesPerSecond [TaskTypeCount]float64
+
+	totalSizeWithKnownOutput [TaskTypeCount]float64 // input sizes, for tasks whose output size is known
+	totalOutputSize          [TaskTypeCount]float64
+	estimatedOutputRatio     [TaskTypeCount]float64 // output bytes per input byte
 }
 
+// a guess, see NewEstimator
+const singlePassFixAudioSpeedUp = 3.0
+
 func NewEstimator() *Estimator {
-	estimator := Estimator{[TaskTypeCount]float64{}, [TaskTypeCount]float64{}, [TaskTypeCount]float64{}}
+	estimator := Estimator{}
 	// these estimates that came from a very long encoding session (on skink in March 2021)
 	// 30.6 MiB kps, Transcode 100.0 KiB
 	estimator.estimatedBytesPerSecond[Concatenate] = 500.0 * 1000 * 1000
 	estimator.estimatedBytesPerSecond[FixAudio]    = 30.6 * 1000 * 1000
 	estimator.estimatedBytesPerSecond[Transcode]   = 100 * 1000
+
+	// ...except that the FixAudio measured then was the legacy one, which reads the source
+	// twice and writes (and reads back) a copy of it. The single pass version reads it once
+	// and writes it once, so it is taken to be three times as fast. That is a guess, not a
+	// measurement, and UpdateEstimates replaces it with the real thing once a FixAudio finishes
+	if settings.fixAudioMode != "legacy" {
+		estimator.estimatedBytesPerSecond[FixAudio] = singlePassFixAudioSpeedUp * 30.6 * 1000 * 1000
+	}
+
+	// a guess, for MPEG-2 (DVD or DVB-ish) sources going to x265 at the default quality
+	estimator.estimatedOutputRatio[Concatenate] = 1.0
+	estimator.estimatedOutputRatio[FixAudio]    = 1.0
+	estimator.estimatedOutputRatio[Transcode]   = 0.3
 	return &estimator
 }
 
@@ -37,6 +57,28 @@ func (e *Estimator) UpdateEstimates(task *Task, workersOfThisType int) {
 	ebpsAllWorkers := e.totalInputSize[taskType] / e.totalRunTime[taskType]
 
 	e.estimatedBytesPerSecond[taskType] = ebpsAllWorkers * float64(workersOfThisType)
+
+	if task.outputSize > 0 && task.inputSize > 0 {
+		e.totalSizeWithKnownOutput[taskType] += float64(task.inputSize)
+		e.totalOutputSize[taskType] += float64(task.outputSize)
+		e.estimatedOutputRatio[taskType] = e.totalOutputSize[taskType] / e.totalSizeWithKnownOutput[taskType]
+	}
+}
+
+// the final outputs are (more or less) the Transcode outputs stuck together
+func (e *Estimator) EstimateOutputSize(tasks []*Task) float64 {
+	total := 0.0
+	for _, task := range tasks {
+		if task.taskType != Transcode {
+			continue
+		}
+		if task.outputSize > 0 {
+			total += float64(task.outputSize)
+		} else {
+			total += float64(task.inputSize) * e.estimatedOutputRatio[Transcode]
+		}
+	}
+	return total
 }
 
 func (e *Estimator) EstimateBytesPerSecond(taskType TaskType) float64 {
@@ -4,87 +4,332 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strings"
 )
 
-func doTranscode(task *Task) {
+// whether the transcode reads the output of a FixAudio, rather than the source itself
+func repairedAudio(task *Task) bool {
+	for _, dependee := range task.dependsOn {
+		if dependee.taskType == FixAudio {
+			return true
+		}
+	}
+	return false
+}
+
+func transcodeArgs(task *Task) []string {
 	args := []string{
 		"--input", task.fileIn,
 		"--output", task.fileOut,
 
-		"--encoder", "x265",
+		"--encoder", task.profile.encoder,
 
-		"--quality", fmt.Sprintf("%d", settings.quality),
+		"--quality", fmt.Sprintf("%d", task.profile.quality),
 		"--two-pass", "--turbo",
 
 		"--aencoder", "copy",
 
 		"--loose-anamorphic"}
 
-	if settings.decomb {
-		args = append(args,
-			"--comb-detect=default",
-			"--decomb=eedi2bob")
+	if settings.audioTracks == "all" {
+		args = append(args, "--all-audio")
+	}
+
+	if settings.audioLanguage != "" {
+		args = append(args, "--audio-lang-list", settings.audioLanguage)
 	}
 
-	if settings.width != "" {
-		args = append(args, "--width", settings.width)
+	// HandBrake can't pass every codec through to an mp4, and a repaired source
+	// (whether by -fixAudio or -autoFixAudio) has the codec of the repair
+	if repairedAudio(task) && settings.audioCodec != "mp3" && settings.audioCodec != "aac" {
+		args = append(args, "--audio-fallback", "av_aac")
 	}
 
-	if settings.height != "" {
-		args = append(args, "--height", settings.height)
+	if crop := cropFor(task.source, task.group); crop != nil {
+		args = append(args, "--crop", crop.String())
 	}
 
-	if settings.fps != "" {
-		args = append(args, "--rate", settings.fps)
+	if shouldDeinterlace(task.source) {
+		args = append(args, deinterlacerArgs(settings.deinterlacer)...)
+	}
+
+	if task.profile.width != "" {
+		args = append(args, "--width", task.profile.width)
+	}
+
+	if task.profile.height != "" {
+		args = append(args, "--height", task.profile.height)
+	}
+
+	if task.profile.fps != "" {
+		args = append(args, "--rate", task.profile.fps)
+	}
+
+	args = append(args, handBrakeSubtitleArgs()...)
+
+	args = append(args, filtersFor(task).HandBrakeArgs()...)
+
+	if trim := trimFor(task); trim != nil {
+		args = append(args, trim.HandBrakeArgs()...)
 	}
 
 	args = append(args, "2>&1")
 
-	invoke("HandBrakeCLI", args)
+	return args
+}
+
+// the same transcode as transcodeArgs, as near as we can get it with ffmpeg (one pass though)
+func ffmpegTranscodeArgs(task *Task) []string {
+	filters := filtersFor(task)
+
+	args := []string{}
+	if trim := trimFor(task); trim != nil {
+		args = append(args, trim.FfmpegInputArgs()...)
+	}
+	args = append(args, "-i", task.fileIn)
+
+	burnIn := burnInFor(task)
+
+	videoFilters := []string{}
+	if shouldDeinterlace(task.source) {
+		videoFilters = append(videoFilters, ffmpegDeinterlaceFilter(settings.deinterlacer))
+	}
+	if burnIn != nil && !isBitmapSubtitle(burnIn.CodecName) {
+		videoFilters = append(videoFilters, textSubtitleFilter(task))
+	}
+	if crop := cropFor(task.source, task.group); crop != nil && crop.String() != "0:0:0:0" {
+		videoFilters = append(videoFilters, crop.ffmpegFilter())
+	}
+	videoFilters = append(videoFilters, filters.FfmpegFilters()...)
+	if scale := task.profile.ffmpegScale(); scale != "" {
+		videoFilters = append(videoFilters, scale)
+	}
+	if task.profile.fps != "" {
+		videoFilters = append(videoFilters, "fps="+task.profile.fps)
+	}
+
+	// bitmap subtitles are a second input to overlay, which takes a filtergraph
+	if burnIn != nil && isBitmapSubtitle(burnIn.CodecName) {
+		graph := append([]string{"[0:v:0][0:s:0]overlay"}, videoFilters...)
+		args = append(args, "-filter_complex", strings.Join(graph, ",")+"[video]", "-map", "[video]")
+	} else {
+		args = append(args, "-map", "0:v:0")
+		if len(videoFilters) > 0 {
+			args = append(args, "-vf", strings.Join(videoFilters, ","))
+		}
+	}
+
+	if settings.audioTracks == "all" {
+		args = append(args, "-map", "0:a?")
+	} else {
+		args = append(args, "-map", "0:a:0?")
+	}
+
+	if settings.subtitles == "keep" {
+		args = append(args, ffmpegSubtitleArgs(task)...)
+	}
+
+	encoder, found := ffmpegEncoders[task.profile.encoder]
+	if !found {
+		encoder = task.profile.encoder
+	}
+
+	return append(args,
+		"-c:v", encoder,
+		"-crf", fmt.Sprintf("%d", task.profile.quality),
+		"-c:a", "copy",
+		"-y",
+		task.fileOut)
+}
+
+// the group's filters, or failing that the profile's
+func filtersFor(task *Task) FilterChain {
+	if task.group != nil {
+		return task.group.FilterChain()
+	}
+	return task.profile.filters
+}
+
+// the source's own segment, or failing that the filter chain's trim (if any)
+func trimFor(task *Task) *Segment {
+	if task.segment != nil {
+		return task.segment
+	}
+	return filtersFor(task).Trim()
+}
+
+func transcodeCommand(task *Task) (string, []string) {
+	if settings.transcoder == "ffmpeg" {
+		return settings.ffmpegPath, ffmpegTranscodeArgs(task)
+	}
+	return settings.handbrakePath, transcodeArgs(task)
+}
+
+func doTranscode(task *Task) {
+	task.invoke(transcodeCommand(task))
 
 	// we dont know for sure whether the input is a temp file or not...
 	//removeTemporaryFile(task.fileIn)
 }
 
-func doFixAudio(task *Task) {
-	audioStream := makeTemporaryFile(".mp3")
-	videoStream := makeTemporaryFile(getFileExtension(task.fileIn))
+// one ffmpeg invocation that copies the video and re-encodes the audio on the way through,
+// so there are no temporary files. The loudness measurements are only needed for
+// -loudnorm (and may be nil, see audiorepair.go)
+func fixAudioSinglePassArgs(task *Task, loudness *Loudness) []string {
+	padTo := fixAudioPadTo(task)
+
+	args := []string{"-i", task.fileIn}
+
+	// the audio comes from a second reading of the same file, so that it can be shifted
+	audioInput := 0
+	if offset := audioOffsetArgs(); len(offset) > 0 {
+		args = append(args, offset...)
+		args = append(args, "-i", task.fileIn)
+		audioInput = 1
+	}
+
+	args = append(args, "-map", task.streams.videoMap(0))
+	args = append(args, task.streams.audioMaps(audioInput)...)
+	args = append(args, "-vcodec", "copy")
+	if filters := audioRepairFilters(loudness, padTo); filters != "" {
+		args = append(args, "-af", filters)
+	}
+	args = append(args, audioRepairSampleRate()...)
+	args = append(args, "-acodec", audioCodecs[settings.audioCodec].encoder)
+	if carryingSubtitles() {
+		args = append(args, fixAudioSubtitleArgs(task, 0)...)
+	}
+	if padTo == 0 {
+		args = append(args, "-shortest")
+	}
+
+	// the output is one of our temporary files, so never worth stopping for
+	return append(args, "-y", task.fileOut)
+}
+
+// pad the audio out to the length of the video, if we know it, rather than cutting
+// whichever is longer down to the length of the other
+func fixAudioPadTo(task *Task) float64 {
+	if settings.audioFit == "pad" {
+		return videoDuration(task)
+	}
+	return 0
+}
+
+// the three ffmpeg invocations of the original repair, via the two temporary files given.
+// Slower, and twice the disk space, but some sources only survive being taken apart first
+func fixAudioArgs(task *Task, videoStream string, audioStream string, loudness *Loudness) [][]string {
+	padTo := fixAudioPadTo(task)
 
 	// demux the video stream (leave encoding as is)
-	args := []string{
+	demuxVideo := []string{
 		"-i", task.fileIn,
-		"-map", "0:0",
+		"-map", task.streams.videoMap(0),
 		"-codec", "copy",
 		videoStream}
 
-	invoke("ffmpeg", args)
-
-	// demux the audio stream and transcode to mp3
-	args = []string{
-		"-i", task.fileIn,
-		"-map", "0:1",
-		"-codec", "mp3",
-		audioStream}
-
-	invoke("ffmpeg", args)
+	// demux the audio stream(s) and re-encode
+	demuxAudio := []string{"-i", task.fileIn}
+	demuxAudio = append(demuxAudio, task.streams.audioMaps(0)...)
+	if filters := audioRepairFilters(loudness, padTo); filters != "" {
+		demuxAudio = append(demuxAudio, "-af", filters)
+	}
+	demuxAudio = append(demuxAudio, audioRepairSampleRate()...)
+	demuxAudio = append(demuxAudio,
+		"-codec", audioCodecs[settings.audioCodec].encoder,
+		audioStream)
 
 	// remux the audio and video streams into a new container
-	args = []string{
-		"-i", videoStream,
-		"-i", audioStream,
+	remux := []string{"-i", videoStream}
+	remux = append(remux, audioOffsetArgs()...)
+	remux = append(remux, "-i", audioStream)
+	if carryingSubtitles() {
+		// the subtitles come straight from the source
+		remux = append(remux, "-i", task.fileIn)
+	}
+	remux = append(remux,
 		"-map", "0:v:0",
-		"-map", "1:a:0",
+		"-map", "1:a",
 		"-acodec", "copy",
-		"-vcodec", "copy",
-		"-shortest",
-		task.fileOut}
+		"-vcodec", "copy")
+	if carryingSubtitles() {
+		remux = append(remux, fixAudioSubtitleArgs(task, 2)...)
+	}
+	if padTo == 0 {
+		remux = append(remux, "-shortest")
+	}
+	remux = append(remux, task.fileOut)
+
+	return [][]string{demuxVideo, demuxAudio, remux}
+}
 
-	invoke("ffmpeg", args)
+// a single stream file can only hold, well, one stream
+func fixAudioStreamExtension(task *Task) string {
+	if task.streams.audioCount() > 1 {
+		return ".mka"
+	}
+	return audioCodecs[settings.audioCodec].extension
+}
+
+func doFixAudio(task *Task) {
+	var loudness *Loudness
+	if settings.loudnorm {
+		var err error
+		if loudness, err = measureLoudness(task); err != nil {
+			Log("Could not measure the loudness of %s, normalising in one pass: %s", task.fileIn, err.Error())
+		}
+	}
+
+	if settings.fixAudioMode == "single" {
+		_, err := task.tryInvoke(settings.ffmpegPath, fixAudioSinglePassArgs(task, loudness))
+		if err == nil {
+			return
+		}
+		Log("Could not fix the audio of %s in one go (%s), trying it the long way", task.fileIn, err.Error())
+		os.Remove(task.fileOut)
+	}
+
+	doFixAudioTheLongWay(task, loudness)
+}
+
+func doFixAudioTheLongWay(task *Task, loudness *Loudness) {
+	audioStream := makeTemporaryFile(fixAudioStreamExtension(task))
+	videoStream := makeTemporaryFile(getFileExtension(task.fileIn))
+
+	for _, args := range fixAudioArgs(task, videoStream, audioStream, loudness) {
+		task.invoke(settings.ffmpegPath, args)
+	}
 
 	removeTemporaryFile(audioStream)
 	removeTemporaryFile(videoStream)
 }
 
+// the metadata file (chapters and tags) is optional
+func concatenateArgs(task *Task, listFile string, metadataFile string) []string {
+	args := []string{
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listFile}
+
+	if metadataFile != "" {
+		args = append(args,
+			"-i", metadataFile,
+			"-map", "0",
+			"-map_metadata", "1",
+			"-map_chapters", "1",
+			"-movflags", "use_metadata_tags") // otherwise the mp4 muxer drops the tags it doesn't know
+	} else if settings.subtitles == "keep" {
+		args = append(args, "-map", "0") // otherwise ffmpeg picks just the one stream of each kind
+	}
+
+	// the .partial file is always ours to overwrite, and the name tells ffmpeg nothing about the format
+	return append(args,
+		"-c", "copy",
+		"-f", "mp4",
+		"-y",
+		partialOutputPath(task.fileOut))
+}
+
 func doConcatenate(task *Task) {
 	listFile := makeTemporaryFile(".list")
 
@@ -107,14 +352,51 @@ func doConcatenate(task *Task) {
 		fatal(fmt.Sprintf("Could not open %s for the concatenation list", listFile))
 	}
 
-	args := []string{
-		"-f", "concat",
-		"-safe", "0",
-		"-i", listFile,
-		"-c", "copy",
-		task.fileOut}
+	metadata := NewFfMetadata()
+
+	if settings.writeTags && task.group != nil {
+		if err := addTagsForGroup(metadata, task.group); err != nil {
+			Log("No tags for %s: %s", task.fileOut, err.Error())
+		}
+	}
+
+	sourceTags := []*SourceTags{}
+	if settings.preserveSourceTags && task.group != nil {
+		sourceTags = addSourceTagsForGroup(metadata, task.group)
+	}
+
+	if settings.chapters {
+		if err := addChaptersForParts(metadata, task); err != nil {
+			Log("No chapters for %s: %s", task.fileOut, err.Error())
+			metadata.chapters = nil
+		}
+	}
+
+	metadataFile := ""
+	if !metadata.IsEmpty() {
+		metadataFile = makeTemporaryFile(".ffmetadata")
+		if err := metadata.WriteTo(metadataFile); err != nil {
+			fatal(fmt.Sprintf("Could not write %s: %s", metadataFile, err.Error()))
+		}
+		if settings.verbose {
+			Log("Wrote chapters and tags to %s", metadataFile)
+		}
+	}
 
-	invoke("ffmpeg", args)
+	args := concatenateArgs(task, listFile, metadataFile)
+
+	task.invoke(settings.ffmpegPath, args)
+
+	promotePartialOutput(task.fileOut)
+
+	// before the parts go, as their lengths are what the subtitles are timed by
+	if settings.subtitles == "extract" {
+		writeSubtitlesSidecar(task)
+	}
+
+	if NeedsSourceTagsSidecar(sourceTags) {
+		writeSourceTagsSidecar(task.fileOut, sourceTags)
+	}
 
 	// we are pretty sure that all of the inputs will be temporary files
 	for _, dependee := range task.dependsOn {
@@ -122,6 +404,26 @@ func doConcatenate(task *Task) {
 	}
 
 	removeTemporaryFile(listFile)
+	if metadataFile != "" {
+		removeTemporaryFile(metadataFile)
+	}
+}
+
+// called once the task is complete (and its run time is known)
+func FinishTask(task *Task) {
+	if task.taskType != Concatenate {
+		return
+	}
+
+	if settings.provenance {
+		writeProvenance(task)
+	}
+
+	if catalogue != nil && task.group != nil {
+		if err := catalogue.Record(task.group); err != nil {
+			Log("Could not update the catalogue: %s", err.Error())
+		}
+	}
 }
 
 func ExecuteTask(task *Task) {
@@ -135,4 +437,8 @@ func ExecuteTask(task *Task) {
 	case Concatenate:
 		doConcatenate(task)
 	}
+
+	if info, err := os.Stat(task.fileOut); err == nil {
+		task.outputSize = info.Size()
+	}
 }
@@ -0,0 +1,125 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+/**
+
+ffmpeg's own metadata file format (https://ffmpeg.org/ffmpeg-formats.html#Metadata-1)
+which is how we get chapters (and tags) into the output of a concatenation:
+
+;FFMETADATA1
+title=Holiday
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=0
+END=12500
+title=sample 1
+
+*/
+
+type FfMetadata struct {
+	tags     [][2]string // ordered, so the file is predictable
+	chapters []ffChapter
+}
+
+type ffChapter struct {
+	title string
+	start float64 // seconds
+	end   float64
+}
+
+func NewFfMetadata() *FfMetadata {
+	return &FfMetadata{}
+}
+
+func (m *FfMetadata) AddTag(key string, value string) {
+	m.tags = append(m.tags, [2]string{key, value})
+}
+
+func (m *FfMetadata) HasTag(key string) bool {
+	for _, tag := range m.tags {
+		if tag[0] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *FfMetadata) AddChapter(title string, start float64, end float64) {
+	m.chapters = append(m.chapters, ffChapter{title, start, end})
+}
+
+func (m *FfMetadata) IsEmpty() bool {
+	return len(m.tags) == 0 && len(m.chapters) == 0
+}
+
+func (m *FfMetadata) String() string {
+	var b strings.Builder
+
+	b.WriteString(";FFMETADATA1\n")
+	for _, tag := range m.tags {
+		fmt.Fprintf(&b, "%s=%s\n", escapeFfMetadata(tag[0]), escapeFfMetadata(tag[1]))
+	}
+
+	for _, chapter := range m.chapters {
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(chapter.start*1000), int64(chapter.end*1000), escapeFfMetadata(chapter.title))
+	}
+
+	return b.String()
+}
+
+func (m *FfMetadata) WriteTo(path string) error {
+	return os.WriteFile(path, []byte(m.String()), 0644)
+}
+
+// '=', ';', '#', '\' and newlines need a backslash in front of them
+func escapeFfMetadata(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// one chapter per dependee of a Concatenate task, named after the source clip
+// (or whatever the manifest said), and timed using the duration of each transcoded part
+func addChaptersForParts(metadata *FfMetadata, task *Task) error {
+	start := 0.0
+	for _, dependee := range task.dependsOn {
+		info, err := probeMediaUncached(dependee.fileOut)
+		if err != nil {
+			return err
+		}
+		if info.Duration <= 0 {
+			return fmt.Errorf("could not find the duration of %s", dependee.fileOut)
+		}
+		metadata.AddChapter(chapterTitle(dependee), start, start+info.Duration)
+		start += info.Duration
+	}
+	return nil
+}
+
+func chapterTitle(task *Task) string {
+	if task.source == nil {
+		return lastBitOfPath(task.fileIn)
+	}
+	title := task.source.chapter
+	if title == "" {
+		name := lastBitOfPath(task.source.path)
+		title = strings.TrimSuffix(name, getFileExtension(name))
+	}
+	if task.segment != nil && task.segment.part != "" {
+		title += " (" + task.segment.part + ")"
+	}
+	return title
+}
@@ -0,0 +1,139 @@
+package varchive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// decides which of the files found by ScanPaths are worth transcoding. In order:
+//
+//    - the extension must be one of settings.extensions (unless that is "all")
+//    - the name must match one of the -include globs (if there are any)
+//    - the name must not match any -exclude glob
+//    - the name must not match any glob in a .varchiveignore file in the same directory
+//
+// globs are matched against the file name, and against the full path
+
+const ignoreFileName = ".varchiveignore"
+
+var defaultVideoExtensions = ".3gp,.avi,.dv,.flv,.m2t,.m2ts,.m2v,.m4v,.mkv,.mod,.mov,.mp4,.mpeg,.mpg,.mts,.mxf,.ogv,.tod,.ts,.vob,.webm,.wmv"
+
+type FileFilter struct {
+	include    []string
+	exclude    []string
+	extensions map[string]bool // empty means anything goes
+
+	lock        sync.Mutex
+	ignoreRules map[string][]string // by directory
+}
+
+func NewFileFilter(include []string, exclude []string, extensions string) *FileFilter {
+	filter := &FileFilter{
+		include:     include,
+		exclude:     exclude,
+		extensions:  make(map[string]bool),
+		ignoreRules: make(map[string][]string),
+	}
+
+	if extensions != "all" {
+		for _, extension := range strings.Split(extensions, ",") {
+			extension = strings.ToLower(strings.TrimSpace(extension))
+			if extension == "" {
+				continue
+			}
+			if !strings.HasPrefix(extension, ".") {
+				extension = "." + extension
+			}
+			filter.extensions[extension] = true
+		}
+	}
+
+	return filter
+}
+
+func newFileFilterFromSettings() *FileFilter {
+	return NewFileFilter(settings.include, settings.exclude, settings.extensions)
+}
+
+// returns the reason for rejecting the file, or an empty string if it is acceptable
+func (f *FileFilter) Check(path string) string {
+	name := filepath.Base(path)
+
+	if len(f.extensions) > 0 && !f.extensions[strings.ToLower(filepath.Ext(name))] {
+		return "not a known video extension"
+	}
+
+	if len(f.include) > 0 && matchesAny(f.include, path) == "" {
+		return "does not match any -include"
+	}
+
+	if glob := matchesAny(f.exclude, path); glob != "" {
+		return fmt.Sprintf("matches -exclude %s", glob)
+	}
+
+	if glob := matchesAny(f.ignoreRulesFor(filepath.Dir(path)), path); glob != "" {
+		return fmt.Sprintf("matches %s in %s", glob, ignoreFileName)
+	}
+
+	return ""
+}
+
+func (f *FileFilter) ignoreRulesFor(directory string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	rules, found := f.ignoreRules[directory]
+	if !found {
+		rules = []string{}
+		if file, err := os.Open(filepath.Join(directory, ignoreFileName)); err == nil {
+			rules = ParseIgnoreRules(file)
+			file.Close()
+		}
+		f.ignoreRules[directory] = rules
+	}
+	return rules
+}
+
+// one glob per line, blank lines and lines starting with # are ignored
+func ParseIgnoreRules(reader io.Reader) []string {
+	rules := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			rules = append(rules, line)
+		}
+	}
+	return rules
+}
+
+// returns the first glob that matches the name or the full path, or an empty string
+func matchesAny(globs []string, path string) string {
+	name := filepath.Base(path)
+	for _, glob := range globs {
+		if matched, _ := filepath.Match(glob, name); matched {
+			return glob
+		}
+		if matched, _ := filepath.Match(glob, path); matched {
+			return glob
+		}
+	}
+	return ""
+}
+
+// optionally, ask ffprobe whether there is any video in there at all
+func sniffForVideo(path string) string {
+	media, err := ProbeMedia(path)
+	if err != nil {
+		return "ffprobe could not read it"
+	}
+	if media.VideoStream() == nil {
+		return "ffprobe found no video stream"
+	}
+	return ""
+}
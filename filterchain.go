@@ -0,0 +1,240 @@
+package varchive
+
+import (
+	"fmt"
+	"strings"
+)
+
+// a FilterChain is the clean-up applied to the video of every source in a group, written as
+// a comma separated list, applied in the order given. For example
+//
+//    denoise=nlmeans:light,deblock,rotate=90,colour=bt709,trim=4s-
+//
+// The filters, and what they take (strengths are ultralight, light, medium or strong):
+//
+//    denoise=[hqdn3d|nlmeans][:strength]   default hqdn3d:medium
+//    deblock[=strength]                    default medium
+//    sharpen[=strength]                    default medium
+//    rotate=auto|90|180|270|hflip|vflip    auto is whatever the source's metadata says,
+//                                          which both HandBrake and ffmpeg do anyway
+//    colour=bt709|bt601-525|bt601-625|bt2020
+//    range=tv|pc
+//    trim=[start]-[end]                    times into the source, e.g. 4s-1m30s (see segments.go)
+//
+// The trim is for every source, see segments.go for trimming sources one by one.
+// The chain comes from -filters (via the profile), or from the group's manifest entry.
+// It maps onto HandBrakeCLI flags, or onto an ffmpeg filtergraph with -transcoder ffmpeg
+
+type VideoFilter struct {
+	Name  string
+	Value string
+}
+
+type FilterChain []*VideoFilter
+
+var filterStrengths = []string{"ultralight", "light", "medium", "strong"}
+
+// ours -> the colourspaces of ffmpeg's colorspace filter, which HandBrake's --colorspace
+// presets share. Both convert the primaries and the transfer characteristics as well as the
+// matrix, so the two transcoders give the same colours
+var colourspaces = map[string]string{
+	"bt709":     "bt709",
+	"bt601-525": "bt601-6-525",
+	"bt601-625": "bt601-6-625",
+	"bt2020":    "bt2020",
+}
+
+func ParseFilterChain(spec string) (FilterChain, error) {
+	chain := FilterChain{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		filter := &VideoFilter{Name: item}
+		if index := strings.Index(item, "="); index >= 0 {
+			filter.Name, filter.Value = item[:index], item[index+1:]
+		}
+		if filter.Name == "color" {
+			filter.Name = "colour"
+		}
+
+		if err := filter.fillInDefaults(); err != nil {
+			return nil, fmt.Errorf("filter '%s': %s", item, err.Error())
+		}
+		chain = append(chain, filter)
+	}
+
+	return chain, nil
+}
+
+func (f *VideoFilter) fillInDefaults() error {
+	switch f.Name {
+	case "denoise":
+		denoiser, strength := f.Value, "medium"
+		if index := strings.Index(f.Value, ":"); index >= 0 {
+			denoiser, strength = f.Value[:index], f.Value[index+1:]
+		}
+		if denoiser == "" {
+			denoiser = "hqdn3d"
+		}
+		if denoiser != "hqdn3d" && denoiser != "nlmeans" {
+			return fmt.Errorf("the denoiser must be 'hqdn3d' or 'nlmeans'")
+		}
+		if !contains(filterStrengths, strength) {
+			return fmt.Errorf("the strength must be one of: %s", strings.Join(filterStrengths, ", "))
+		}
+		f.Value = denoiser + ":" + strength
+
+	case "deblock", "sharpen":
+		if f.Value == "" {
+			f.Value = "medium"
+		}
+		if !contains(filterStrengths, f.Value) {
+			return fmt.Errorf("the strength must be one of: %s", strings.Join(filterStrengths, ", "))
+		}
+
+	case "rotate":
+		switch f.Value {
+		case "auto", "90", "180", "270", "hflip", "vflip":
+		default:
+			return fmt.Errorf("must be one of auto, 90, 180, 270, hflip or vflip")
+		}
+
+	case "colour":
+		if _, found := colourspaces[f.Value]; !found {
+			return fmt.Errorf("must be one of bt709, bt601-525, bt601-625 or bt2020")
+		}
+
+	case "range":
+		if f.Value != "tv" && f.Value != "pc" {
+			return fmt.Errorf("must be 'tv' or 'pc'")
+		}
+
+	case "trim":
+		if _, err := parseSegment(f.Value); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown filter (known filters are denoise, deblock, sharpen, rotate, colour, range and trim)")
+	}
+	return nil
+}
+
+// the trim, if there is one (the last one wins)
+func (c FilterChain) Trim() *Segment {
+	var trim *Segment
+	for _, filter := range c {
+		if filter.Name == "trim" {
+			trim, _ = parseSegment(filter.Value)
+		}
+	}
+	return trim
+}
+
+func (c FilterChain) String() string {
+	items := []string{}
+	for _, filter := range c {
+		if filter.Value == "" {
+			items = append(items, filter.Name)
+		} else {
+			items = append(items, filter.Name+"="+filter.Value)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (c FilterChain) HandBrakeArgs() []string {
+	args := []string{}
+
+	for _, filter := range c {
+		switch filter.Name {
+		case "denoise":
+			parts := strings.SplitN(filter.Value, ":", 2)
+			args = append(args, fmt.Sprintf("--%s=%s", parts[0], parts[1]))
+
+		case "deblock":
+			args = append(args, "--deblock="+filter.Value)
+
+		case "sharpen":
+			args = append(args, "--lapsharp="+filter.Value)
+
+		case "rotate":
+			switch filter.Value {
+			case "hflip":
+				args = append(args, "--rotate=angle=0:hflip=1")
+			case "vflip":
+				args = append(args, "--rotate=angle=180:hflip=1")
+			case "90", "180", "270":
+				args = append(args, "--rotate=angle="+filter.Value+":hflip=0")
+			}
+
+		case "colour":
+			args = append(args, "--colorspace="+colourspaces[filter.Value])
+
+		case "range":
+			if filter.Value == "pc" {
+				args = append(args, "--color-range=full")
+			} else {
+				args = append(args, "--color-range=limited")
+			}
+		}
+	}
+
+	return args
+}
+
+var hqdn3dStrengths = map[string]float64{"ultralight": 1, "light": 2, "medium": 4, "strong": 7}
+var nlmeansStrengths = map[string]float64{"ultralight": 1, "light": 2, "medium": 4, "strong": 8}
+var sharpenStrengths = map[string]float64{"ultralight": 0.25, "light": 0.5, "medium": 1, "strong": 1.5}
+
+// the filters as an ffmpeg filtergraph, one entry per filter (the trim is done with
+// input options, see Segment.FfmpegInputArgs)
+func (c FilterChain) FfmpegFilters() []string {
+	filters := []string{}
+
+	for _, filter := range c {
+		switch filter.Name {
+		case "denoise":
+			parts := strings.SplitN(filter.Value, ":", 2)
+			if parts[0] == "nlmeans" {
+				filters = append(filters, fmt.Sprintf("nlmeans=s=%g", nlmeansStrengths[parts[1]]))
+			} else {
+				filters = append(filters, fmt.Sprintf("hqdn3d=%g", hqdn3dStrengths[parts[1]]))
+			}
+
+		case "deblock":
+			if filter.Value == "ultralight" || filter.Value == "light" {
+				filters = append(filters, "deblock=filter=weak")
+			} else {
+				filters = append(filters, "deblock=filter=strong")
+			}
+
+		case "sharpen":
+			filters = append(filters, fmt.Sprintf("unsharp=5:5:%g", sharpenStrengths[filter.Value]))
+
+		case "rotate":
+			switch filter.Value {
+			case "90":
+				filters = append(filters, "transpose=clock")
+			case "180":
+				filters = append(filters, "hflip,vflip")
+			case "270":
+				filters = append(filters, "transpose=cclock")
+			case "hflip", "vflip":
+				filters = append(filters, filter.Value)
+			}
+
+		case "colour":
+			filters = append(filters, "colorspace=all="+colourspaces[filter.Value])
+
+		case "range":
+			filters = append(filters, "scale=out_range="+filter.Value)
+		}
+	}
+
+	return filters
+}
@@ -1,17 +1,21 @@
 package varchive
 
 import (
-	"path/filepath"
 	"sort"
 	"fmt"
+	"strings"
 )
 
 func NewFixAudioTask(fileIn *FileWithSize, fileOut string) *Task {
-	return NewTask(FixAudio, fileIn.path, fileOut, fileIn.size)
+	task := NewTask(FixAudio, fileIn.path, fileOut, fileIn.size)
+	task.source = fileIn
+	return task
 }
 
 func NewTranscodeTask(fileIn *FileWithSize, fileOut string) *Task {
-	return NewTask(Transcode, fileIn.path, fileOut, fileIn.size)
+	task := NewTask(Transcode, fileIn.path, fileOut, fileIn.size)
+	task.source = fileIn
+	return task
 }
 
 func NewConcatenateTask(fileOut string, dependsOn []*Task) *Task {
@@ -20,16 +24,46 @@ func NewConcatenateTask(fileOut string, dependsOn []*Task) *Task {
 	return task
 }
 
+// the final task for a group, which the provenance and the catalogue are written for
+func NewGroupConcatenateTask(group *Group, dependsOn []*Task) *Task {
+	task := NewConcatenateTask(group.OutputPath(), dependsOn)
+	task.profile = group.profile
+	task.group = group
+	return task
+}
+
 func GetBusy() {
 
-	if settings.reportSizes {
-		ReportSizes()
+	if settings.doctor {
+		Doctor()
+		return
+	}
+
+	// probes made after the prefetch (for the plan, the estimates, the subtitles...)
+	defer getProbeCache().Save()
+
+	// before anything is run over the sources, and the rest once we know what they need
+	var capabilities *Capabilities
+	if settings.checkTools {
+		capabilities = checkTools()
+	}
+
+	groups := CollectGroups()
+
+	if settings.checkTools {
+		checkCapabilities(capabilities, groups)
+	}
+
+	if settings.reportSizes || settings.inventory != "" {
+		ReportSizes(groups)
+	} else if settings.suggestTrims {
+		SuggestTrims(groups)
 	} else {
 		if settings.verbose {
 			Log("Settings: %v", settings)
 		}
 	
-		tasks := GenerateTasks()
+		tasks := GenerateTasks(groups)
 
 		SortTasks(tasks)
 
@@ -38,39 +72,94 @@ func GetBusy() {
 				Log("%v\n", task)
 			}
 		}
-		ScheduleTasks(NewTimer(), tasks)
+
+		if settings.graphFile != "" {
+			if err := WriteGraph(settings.graphFile, tasks); err != nil {
+				fatal(err.Error())
+			}
+		}
+
+		if settings.dryRun {
+			prefetchMediaInfo(allFilesInGroups(groupsOf(tasks)))
+			PrintPlan(tasks)
+		} else {
+			ScheduleTasks(NewTimer(), tasks)
+		}
 	}
 }
 
-func ReportSizes() {
-	paths := ScanPaths()
+func ReportSizes(groups []*Group) {
 
 	widths := NewHisto()
 	heights := NewHisto()
 	fpses := NewHisto()
+	geometries := NewHisto()
+
+	prefetchMediaInfo(allFilesInGroups(groups))
 
-	for path, files := range paths {
+	if settings.deinterlace == "auto" {
+		detectInterlacing(allFilesInGroups(groups))
+	}
+
+	if detectingCrops() {
+		detectCrops(allFilesInGroups(groups))
+	}
+
+	rows := []*InventoryRow{}
+	rowsByGroup := make(map[*Group][]*InventoryRow)
+
+	for _, group := range groups {
 		if settings.verbose {
-			Log("Path: %s has %d files", path, len(files))
+			Log("Group: %v", group)
 		}
 
-		for _, file := range files {
-			info, err := GetVideoInfoUsingFfProbe(file.path)
-			if err == nil {
-				Log("%s %dx%d %.2f (%.2f)", file.path, info.Width, info.Height, info.Fps, info.Tbr)
-				widths.Add(fmt.Sprintf("%d", info.Width))
-				heights.Add(fmt.Sprintf("%d", info.Height))
-				fpses.Add(fmt.Sprintf("%.2f", info.Fps))
+		groupRows := []*InventoryRow{}
+
+		for _, file := range group.files {
+			media, err := ProbeMedia(file.path)
+			row := NewInventoryRow(group, file, media)
+			if err != nil {
+				row.Error = err.Error()
 			} else {
-				Log("%s %s", file.path, err.Error())
+				if settings.deinterlace == "auto" {
+					row.Interlaced = interlacingOf(file).String()
+				}
+				if crop := cropFor(file, group); crop != nil {
+					row.Crop = crop.String()
+				}
 			}
+
+			if row.Error == "" {
+				Log("%s %dx%d %.2f %s", file.path, row.Width, row.Height, row.Fps, row.Codec)
+				widths.Add(fmt.Sprintf("%d", row.Width))
+				heights.Add(fmt.Sprintf("%d", row.Height))
+				fpses.Add(fmt.Sprintf("%.2f", row.Fps))
+				geometries.Add(row.Geometry())
+			} else {
+				Log("%s %s", file.path, row.Error)
+			}
+
+			groupRows = append(groupRows, row)
 		}
+
+		rows = append(rows, groupRows...)
+		rowsByGroup[group] = groupRows
 	}
 
-	Log("\n\n  Widths:\n%v\n  Heights:\n%v  FPSes:\n%v", widths, heights, fpses)
+	Log("\n\n  Widths:\n%v\n  Heights:\n%v\n  FPSes:\n%v\n  Geometries:\n%v",
+		renderHisto(widths), renderHisto(heights), renderHisto(fpses), renderHisto(geometries))
+
+	Log("Groups:")
+	for _, group := range groups {
+		logGroupSummary(group, rowsByGroup[group])
+	}
+
+	if settings.inventory != "" {
+		writeInventoryFile(rows)
+	}
 }
 
-func GenerateTasks() []*Task {
+func GenerateTasks(groups []*Group) []*Task {
 
 	if settings.verbose {
 		Log("Generating tasks")
@@ -80,44 +169,110 @@ func GenerateTasks() []*Task {
 
 	tasks := []*Task{}
 
-	paths := ScanPaths()
+	if settings.useCatalogue {
+		var err error
+		catalogue, err = OpenCatalogue(cataloguePath())
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if catalogue != nil {
+		groups = filterGroupsUsingCatalogue(groups)
+	}
 
-	for path, files := range paths {
+	groups = resolveOutputCollisions(groups)
+
+	if settings.fixAudio || settings.autoFixAudio || settings.deinterlace == "auto" || detectingCrops() || settings.subtitles != "drop" {
+		prefetchMediaInfo(allFilesInGroups(groups))
+	}
+
+	if settings.deinterlace == "auto" {
+		detectInterlacing(allFilesInGroups(groups))
+	}
+
+	if detectingCrops() {
+		detectCrops(allFilesInGroups(groups))
+	}
+
+	var audioDiagnoses map[*FileWithSize][]string
+	if settings.autoFixAudio && !settings.fixAudio {
+		audioDiagnoses = diagnoseAudio(allFilesInGroups(groups))
+	}
+
+	for _, group := range groups {
 		if settings.verbose {
-			Log("Path: %s has %d file(s)", path, len(files))
+			Log("Group: %v", group)
+		}
+
+		if settings.dryRun || settings.verbose {
+			logGroupOrder(group)
+		}
+
+		if settings.subtitles == "keep" {
+			checkSubtitlesAgree(group)
 		}
 
 		concatenateDependees := []*Task{}
 
-		for _, file := range files {
+		for _, file := range group.files {
 
+			var streams *StreamSelection
 			fileIn := file
-			fileOut := makeTemporaryFile(".mp4")
-			transcodeTask := NewTranscodeTask(file, fileOut)
-			transcodeTask.inputSize = fileIn.size
-			tasks = append(tasks, transcodeTask)
-
-			if settings.fixAudio {
-				existingExtension := getFileExtension(fileIn.path)
-				fixAudioFileOut := makeTemporaryFile(existingExtension)
+
+			// one Transcode for each part of the source that is kept (see segments.go)
+			transcodeTasks := []*Task{}
+			segments := segmentsToTranscode(file)
+			if segments == nil {
+				segments = []*Segment{nil}
+			}
+			for _, segment := range segments {
+				fileOut := makeTemporaryFile(".mp4")
+				transcodeTask := NewTranscodeTask(file, fileOut)
+				transcodeTask.inputSize = fileIn.size
+				if segment != nil {
+					transcodeTask.inputSize = segmentSize(file, segment)
+				}
+				transcodeTask.profile = group.profile
+				transcodeTask.group = group
+				transcodeTask.segment = segment
+				tasks = append(tasks, transcodeTask)
+				transcodeTasks = append(transcodeTasks, transcodeTask)
+			}
+
+			fixAudio := settings.fixAudio
+			if problems := audioDiagnoses[file]; len(problems) > 0 {
+				Log("%s needs its audio fixing: %s", file.path, strings.Join(problems, ", "))
+				fixAudio = true
+			}
+
+			if fixAudio {
+				streams = selectStreamsFor(file)
+				if streams != nil && len(streams.Audio) == 0 {
+					Log("%s has no audio, so no audio to fix", file.path)
+				}
+			}
+
+			if fixAudio && (streams == nil || len(streams.Audio) > 0) {
+				fixAudioFileOut := makeTemporaryFile(fixAudioOutputExtension(fileIn.path))
 				fixAudioTask := NewFixAudioTask(file, fixAudioFileOut)
 				fixAudioTask.inputSize = fileIn.size
+				fixAudioTask.profile = group.profile
+				fixAudioTask.group = group
+				fixAudioTask.streams = streams
 
-				transcodeTask.fileIn = fixAudioFileOut
-				transcodeTask.addDependant(fixAudioTask)
+				for _, transcodeTask := range transcodeTasks {
+					transcodeTask.fileIn = fixAudioFileOut
+					transcodeTask.addDependant(fixAudioTask)
+				}
 
 				tasks = append(tasks, fixAudioTask)
 			}
 
-			concatenateDependees = append(concatenateDependees, transcodeTask)
+			concatenateDependees = append(concatenateDependees, transcodeTasks...)
 		}
 
-		finalFileName := lastBitOfPath(path)
-		finalFileOut := filepath.Join(settings.outputRoot, sanitisePath(finalFileName)+".mp4")
-
-		failIfConcatenationFileAlreadyExists(finalFileOut)
-
-		finalTask := NewConcatenateTask(finalFileOut, concatenateDependees)
+		finalTask := NewGroupConcatenateTask(group, concatenateDependees)
 		tasks = append(tasks, finalTask)
 	}
 
@@ -126,6 +281,43 @@ func GenerateTasks() []*Task {
 	return tasks
 }
 
+func filterGroupsUsingCatalogue(groups []*Group) []*Group {
+	result := []*Group{}
+
+	for _, group := range groups {
+		decision := catalogue.Check(group)
+		if decision != NotCatalogued {
+			catalogue.RestoreOutput(group)
+		}
+
+		switch {
+		case decision == Unchanged && !settings.force:
+			Log("Skipping %s, it is already up to date (use -force to rebuild it)", group.OutputPath())
+			continue
+
+		case decision == Unchanged || decision == Changed:
+			Log("Rebuilding %s", group.OutputPath())
+			group.overwrite = true // we made it, so we can replace it
+
+		default:
+			catalogue.ReportSourcesArchivedElsewhere(group)
+		}
+		result = append(result, group)
+	}
+
+	return result
+}
+
+func groupsOf(tasks []*Task) []*Group {
+	groups := []*Group{}
+	for _, task := range tasks {
+		if task.taskType == Concatenate && task.group != nil {
+			groups = append(groups, task.group)
+		}
+	}
+	return groups
+}
+
 // put the FixAudio tasks at the front of the queue, ordered by the
 // size of their inputs, then Transcode tasks, again, order by input size
 //
@@ -0,0 +1,126 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// renders the task graph, coloured by the state of each task, either in Graphviz's DOT language
+//
+//    varchive -graph tasks.dot ... && dot -Tsvg tasks.dot > tasks.svg
+//
+// or as a Mermaid flowchart (if the file name ends in .mmd or .mermaid).
+// With -graph, the monitor rewrites the file as each task starts and ends.
+
+var graphStateColours = map[string]string{
+	"pending":  "#eeeeee",
+	"runnable": "#cce5ff",
+	"running":  "#ffe08a",
+	"complete": "#b7e4b0",
+}
+
+var graphStates = []string{"pending", "runnable", "running", "complete"}
+
+func graphState(task *Task) string {
+	switch {
+	case task.taskState == Running:
+		return "running"
+	case task.taskState == Complete:
+		return "complete"
+	case task.canRun():
+		return "runnable"
+	default:
+		return "pending"
+	}
+}
+
+func RenderDot(tasks []*Task) string {
+	var b strings.Builder
+
+	b.WriteString("digraph varchive {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled, fontname=\"sans-serif\"];\n")
+
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "  t%d [label=\"%s\", fillcolor=\"%s\"];\n",
+			task.id, escapeDot(graphLabel(task)), graphStateColours[graphState(task)])
+	}
+
+	for _, task := range tasks {
+		for _, dependee := range task.dependsOn {
+			fmt.Fprintf(&b, "  t%d -> t%d;\n", dependee.id, task.id)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func RenderMermaid(tasks []*Task) string {
+	var b strings.Builder
+
+	b.WriteString("flowchart LR\n")
+
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "  t%d[\"%s\"]\n", task.id, escapeMermaid(graphLabel(task)))
+	}
+
+	for _, task := range tasks {
+		for _, dependee := range task.dependsOn {
+			fmt.Fprintf(&b, "  t%d --> t%d\n", dependee.id, task.id)
+		}
+	}
+
+	for _, state := range graphStates {
+		fmt.Fprintf(&b, "  classDef %s fill:%s\n", state, graphStateColours[state])
+	}
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "  class t%d %s\n", task.id, graphState(task))
+	}
+
+	return b.String()
+}
+
+func isMermaidFile(path string) bool {
+	extension := strings.ToLower(getFileExtension(path))
+	return extension == ".mmd" || extension == ".mermaid"
+}
+
+// written to the side and renamed, so anything watching the file never sees half a graph
+func WriteGraph(path string, tasks []*Task) error {
+	var graph string
+	if isMermaidFile(path) {
+		graph = RenderMermaid(tasks)
+	} else {
+		graph = RenderDot(tasks)
+	}
+
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, []byte(graph), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
+
+func graphLabel(task *Task) string {
+	file := task.fileIn
+	if task.source != nil {
+		file = task.source.path
+	}
+	if task.taskType == Concatenate {
+		file = task.fileOut
+	}
+	return fmt.Sprintf("#%d %s\n%s\n%s", task.id, task.TaskType(), task.Size(), lastBitOfPath(file))
+}
+
+func escapeDot(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	text = strings.ReplaceAll(text, `"`, `\"`)
+	return strings.ReplaceAll(text, "\n", `\n`)
+}
+
+func escapeMermaid(text string) string {
+	text = strings.ReplaceAll(text, `"`, "#quot;")
+	return strings.ReplaceAll(text, "\n", "<br/>")
+}
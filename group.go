@@ -0,0 +1,104 @@
+package varchive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// a Group is a set of source files that end up concatenated into one output file.
+//
+// Groups come either from a directory on the command line (one directory, one group,
+// named after the directory) or from an entry in a manifest file
+
+type Group struct {
+	name        string // the output file name, without the extension
+	files       FilesWithSize
+	profile     *Profile
+	title       string
+	date        string
+	description string
+	overwrite   bool        // an existing output may be replaced
+	output      string      // only set when the output is not where it would normally go
+	filters     FilterChain // nil means the profile's
+}
+
+func (g *Group) FilterChain() FilterChain {
+	if g.filters != nil {
+		return g.filters
+	}
+	return g.profile.filters
+}
+
+func (g *Group) OutputPath() string {
+	if g.output != "" {
+		return g.output
+	}
+	return g.intendedOutputPath()
+}
+
+// where the output would go if nothing were in the way (see collision.go)
+func (g *Group) intendedOutputPath() string {
+	return filepath.Join(settings.outputRoot, sanitisePath(g.name)+".mp4")
+}
+
+func (g *Group) String() string {
+	return fmt.Sprintf("%s (%d file(s), profile %s)", g.name, len(g.files), g.profile.name)
+}
+
+// builds the complete list of groups, in a predictable order: command line paths
+// first (in the order given), then the manifest entries (in the order written)
+func CollectGroups() []*Group {
+	groups := []*Group{}
+
+	if len(settings.paths) > 0 {
+		defaultProfile, err := lookupProfile("")
+		if err != nil {
+			fatal(err.Error())
+		}
+
+		pathsAndFiles := ScanPaths()
+		for _, path := range settings.paths {
+			files, found := pathsAndFiles[path]
+			if !found || len(files) == 0 {
+				continue
+			}
+			name := lastBitOfPath(path)
+			if len(files) == 1 && files[0].path == path {
+				// a file on its own, so "clip.mpg" becomes "clip.mp4" rather than "clip.mpg.mp4"
+				name = strings.TrimSuffix(name, getFileExtension(name))
+			}
+			group := &Group{
+				name:    name,
+				files:   files,
+				profile: defaultProfile,
+				title:   name,
+			}
+			OrderGroupMembers(group)
+			groups = append(groups, group)
+		}
+	}
+
+	if settings.manifest != "" {
+		manifestGroups, err := ReadManifest(settings.manifest)
+		if err != nil {
+			fatal(err.Error())
+		}
+		groups = append(groups, manifestGroups...)
+	}
+
+	failIfGroupsShareAnOutput(groups)
+
+	return groups
+}
+
+func failIfGroupsShareAnOutput(groups []*Group) {
+	seen := make(map[string]*Group)
+	for _, group := range groups {
+		key := strings.ToLower(group.OutputPath())
+		if other, found := seen[key]; found {
+			fatal(fmt.Sprintf("'%s' and '%s' would both be written to %s", other.title, group.title, group.OutputPath()))
+		}
+		seen[key] = group
+	}
+}
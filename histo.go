@@ -2,6 +2,7 @@ package varchive
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -21,11 +22,36 @@ func (h *Histo) Get(key string) int64 {
 	return h.counts[key]
 }
 
+// in 'natural' key order (so 720 comes before 1080)
+func (h *Histo) Keys() []string {
+	keys := []string{}
+	for key := range h.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return NaturalLess(keys[i], keys[j]) })
+	return keys
+}
+
+// most common first (ties in key order)
+func (h *Histo) KeysByCount() []string {
+	keys := h.Keys()
+	sort.SliceStable(keys, func(i, j int) bool { return h.counts[keys[i]] > h.counts[keys[j]] })
+	return keys
+}
+
 func (h *Histo) String() string {
+	return h.render(h.Keys())
+}
+
+func (h *Histo) StringByCount() string {
+	return h.render(h.KeysByCount())
+}
+
+func (h *Histo) render(keys []string) string {
 	var b strings.Builder
 
-	for key, count := range h.counts {
-		fmt.Fprintf(&b, " %5.d @ %v\n", count, key)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %5.d @ %v\n", h.counts[key], key)
 	}
 
 	return b.String()
@@ -0,0 +1,160 @@
+package varchive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// -reportSizes (and -inventory) probe every source file. The per-file details can be written
+// as CSV or JSON, and the log gets histograms of the geometries plus a summary of each group
+
+type InventoryRow struct {
+	Group      string  `json:"group"`
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`
+	Duration   float64 `json:"duration"`
+	Codec      string  `json:"codec"`
+	Width      int64   `json:"width"`
+	Height     int64   `json:"height"`
+	Fps        float64 `json:"fps"`
+	Interlaced string  `json:"interlaced"`
+	Crop       string  `json:"crop,omitempty"` // top:bottom:left:right, with -autoCrop
+	Audio      string  `json:"audio"`
+	Error      string  `json:"error,omitempty"`
+}
+
+var inventoryColumns = []string{"group", "path", "size", "duration", "codec", "width", "height", "fps", "interlaced", "crop", "audio", "error"}
+
+func NewInventoryRow(group *Group, file *FileWithSize, media MediaInfo) *InventoryRow {
+	row := &InventoryRow{Group: group.name, Path: file.path, Size: file.size, Duration: media.Duration}
+
+	if video := media.VideoStream(); video != nil {
+		row.Codec = video.CodecName
+		row.Width = video.Width
+		row.Height = video.Height
+		row.Fps = video.Fps
+		row.Interlaced = describeFieldOrder(video.FieldOrder)
+	} else {
+		row.Error = "no video stream"
+	}
+
+	audio := []string{}
+	for _, stream := range media.StreamsOfType("audio") {
+		description := fmt.Sprintf("%s %dch %dHz", stream.CodecName, stream.Channels, stream.SampleRate)
+		if stream.Language != "" {
+			description += " " + stream.Language
+		}
+		audio = append(audio, description)
+	}
+	row.Audio = strings.Join(audio, "; ")
+
+	return row
+}
+
+func describeFieldOrder(fieldOrder string) string {
+	switch fieldOrder {
+	case "progressive":
+		return "no"
+	case "tt", "tb":
+		return "yes (top field first)"
+	case "bb", "bt":
+		return "yes (bottom field first)"
+	default:
+		return "unknown"
+	}
+}
+
+func (r *InventoryRow) Geometry() string {
+	return fmt.Sprintf("%dx%d@%.2f", r.Width, r.Height, r.Fps)
+}
+
+func (r *InventoryRow) csvRecord() []string {
+	return []string{
+		r.Group,
+		r.Path,
+		fmt.Sprintf("%d", r.Size),
+		fmt.Sprintf("%.3f", r.Duration),
+		r.Codec,
+		fmt.Sprintf("%d", r.Width),
+		fmt.Sprintf("%d", r.Height),
+		fmt.Sprintf("%.3f", r.Fps),
+		r.Interlaced,
+		r.Crop,
+		r.Audio,
+		r.Error,
+	}
+}
+
+func WriteInventory(writer io.Writer, format string, rows []*InventoryRow) error {
+	if format == "json" {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+
+	w := csv.NewWriter(writer)
+	w.Write(inventoryColumns)
+	for _, row := range rows {
+		w.Write(row.csvRecord())
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeInventoryFile(rows []*InventoryRow) {
+	if settings.inventoryFile == "" {
+		if err := WriteInventory(os.Stdout, settings.inventory, rows); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+
+	file, err := os.Create(settings.inventoryFile)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer file.Close()
+
+	if err := WriteInventory(file, settings.inventory, rows); err != nil {
+		fatal(err.Error())
+	}
+	Log("Inventory written to %s", settings.inventoryFile)
+}
+
+func renderHisto(h *Histo) string {
+	if settings.histoSort == "count" {
+		return h.StringByCount()
+	}
+	return h.String()
+}
+
+func logGroupSummary(group *Group, rows []*InventoryRow) {
+	totalSize, totalDuration, failures := int64(0), 0.0, 0
+	geometries := NewHisto()
+
+	for _, row := range rows {
+		totalSize += row.Size
+		totalDuration += row.Duration
+		if row.Error != "" {
+			failures++
+		} else {
+			geometries.Add(row.Geometry())
+		}
+	}
+
+	summary := fmt.Sprintf("%s: %d file(s), %s, %s", group.name, len(rows), niceSize(totalSize), niceTime(totalDuration))
+	if keys := geometries.KeysByCount(); len(keys) > 0 {
+		summary += fmt.Sprintf(", mostly %s", keys[0])
+		if len(keys) > 1 {
+			summary += fmt.Sprintf(" (%d different geometries, concatenation may need -width/-height/-fps)", len(keys))
+		}
+	}
+	if failures > 0 {
+		summary += fmt.Sprintf(", %d could not be probed", failures)
+	}
+	Log(summary)
+}
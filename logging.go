@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 func Log(message string, variables ...interface{}) {
@@ -19,17 +22,159 @@ func Log(message string, variables ...interface{}) {
 func InitialiseLogging() {
 
 	if settings.logToFile != "" {
-		file, err := os.OpenFile(settings.logToFile, os.O_CREATE|os.O_WRONLY, 0666)
+		sink, err := OpenLogSink(settings.logToFile, settings.logMode, int64(settings.logMaxSize)*1024*1024, settings.logKeep)
 		if err != nil {
 			fatal(err.Error())
 
 		} else {
-			log.SetOutput(file)
+			log.SetOutput(sink)
 		}
 	} else {
-		log.SetOutput(os.Stdout)
-		if settings.verbose {
+		log.SetOutput(consoleWriter())
+		if settings.verbose && consoleWriter() == os.Stdout {
 			log.Println("Logging to StdOut")
 		}
 	}
 }
+
+// when standard output carries the results (an inventory, a plan, suggested trims or the
+// doctor's report) messages go to standard error, so the results can be piped elsewhere
+func resultsOnStdout() bool {
+	return (settings.inventory != "" && settings.inventoryFile == "") ||
+		(settings.dryRun && settings.planFile == "") ||
+		settings.suggestTrims || settings.doctor
+}
+
+func consoleWriter() *os.File {
+	if resultsOnStdout() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// every run, and every file, gets a header so that a log shared by many runs (i.e. 'append'
+// or 'rotate' modes) can still be picked apart. It is written straight to the file, as the
+// sink can't go back through the log package in the middle of a Write
+func logHeader() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	lines := []string{
+		"==========================================================",
+		fmt.Sprintf("varchive %s on %s (pid %d)", Version, host, os.Getpid()),
+		fmt.Sprintf("Arguments: %v", os.Args[1:]),
+		fmt.Sprintf("Settings: %+v", settings),
+		"==========================================================",
+	}
+
+	// the same timestamps as the log package
+	now := time.Now().Format("2006/01/02 15:04:05 ")
+	return now + strings.Join(lines, "\n"+now) + "\n"
+}
+
+// LogSink is the io.Writer behind the log package when logging to a file.
+// In 'rotate' mode it moves the current file out of the way (log -> log.1 -> log.2 ...)
+// either at the start of every run (maxSize == 0) or whenever the file would grow past maxSize
+type LogSink struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	rotate  bool
+	maxSize int64
+	keep    int
+}
+
+// OpenLogSink opens the log file according to the mode ('append', 'truncate' or 'rotate'),
+// and starts it off with the header for this run
+func OpenLogSink(path string, mode string, maxSize int64, keep int) (*LogSink, error) {
+	sink := &LogSink{path: path, rotate: mode == "rotate", maxSize: maxSize, keep: keep}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	switch mode {
+	case "truncate":
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	case "rotate":
+		if info, err := os.Stat(path); err == nil {
+			if maxSize == 0 || info.Size() >= maxSize {
+				if err := sink.shuffleOldFiles(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := sink.open(flags); err != nil {
+		return nil, err
+	}
+	if err := sink.writeHeader(); err != nil {
+		sink.file.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *LogSink) writeHeader() error {
+	n, err := s.file.WriteString(logHeader())
+	s.size += int64(n)
+	return err
+}
+
+func (s *LogSink) open(flags int) error {
+	file, err := os.OpenFile(s.path, flags, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// log.N is discarded, log.N-1 becomes log.N, ... and finally log becomes log.1
+func (s *LogSink) shuffleOldFiles() error {
+	if s.keep < 1 {
+		return os.Remove(s.path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.keep))
+	for n := s.keep - 1; n > 0; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, n), fmt.Sprintf("%s.%d", s.path, n+1))
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+func (s *LogSink) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rotate && s.maxSize > 0 && s.size > 0 && s.size+int64(len(p)) > s.maxSize {
+		s.file.Close()
+		if err := s.shuffleOldFiles(); err != nil {
+			// carry on with the file we had, rather than lose everything from here on
+			if err := s.open(os.O_CREATE | os.O_WRONLY | os.O_APPEND); err != nil {
+				return 0, err
+			}
+			fmt.Fprintf(s.file, "Could not rotate the log: %s\n", err.Error())
+		} else {
+			if err := s.open(os.O_CREATE | os.O_WRONLY | os.O_TRUNC); err != nil {
+				return 0, err
+			}
+			s.writeHeader()
+		}
+	}
+
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *LogSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,231 @@
+package varchive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/**
+
+A manifest lists, for each output archive, the source files (in order) that go into it.
+Relative source paths are relative to the directory containing the manifest.
+
+JSON:
+
+[
+  {
+    "output": "holiday-1998",
+    "profile": "default",
+    "title": "Cornwall, summer 1998",
+    "date": "1998-08-01",
+    "description": "Tapes 3 and 4",
+    "filters": "denoise=hqdn3d:light,deblock",
+    "sources": ["tape3/capture 1.mpg", "/mnt/nas/tape4/capture.mpg"],
+    "chapters": ["Arrival", "The beach"],
+    "trims": ["0:04-", "-1:02:30"]
+  }
+]
+
+CSV (needs a header row, one row per source, rows for one output are kept in order):
+
+output,source,chapter,profile,title,date,description,filters,trim
+holiday-1998,tape3/capture 1.mpg,Arrival,default,"Cornwall, summer 1998",1998-08-01,Tapes 3 and 4,"denoise=hqdn3d:light,deblock",0:04-
+holiday-1998,/mnt/nas/tape4/capture.mpg,The beach,,,,,,-1:02:30
+
+'output' is a file name, the archive always goes in the output root.
+'chapters' (or the 'chapter' column) is optional, by default chapters are named after the source file.
+'filters' is optional too, and replaces the profile's filter chain (see filterchain.go)
+'trims' (or the 'trim' column) is optional, the parts of each source to keep (see segments.go)
+
+*/
+
+type ManifestEntry struct {
+	Output      string   `json:"output"`
+	Profile     string   `json:"profile"`
+	Title       string   `json:"title"`
+	Date        string   `json:"date"`
+	Description string   `json:"description"`
+	Filters     string   `json:"filters"`
+	Sources     []string `json:"sources"`
+	Chapters    []string `json:"chapters"`
+	Trims       []string `json:"trims"`
+}
+
+func ReadManifest(path string) ([]*Group, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*ManifestEntry
+	if strings.ToLower(getFileExtension(path)) == ".csv" {
+		entries, err = ParseManifestCsv(file)
+	} else {
+		entries, err = ParseManifestJson(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	if settings.verbose {
+		Log("Manifest %s has %d entries", path, len(entries))
+	}
+
+	return groupsFromManifestEntries(entries, filepath.Dir(path))
+}
+
+func ParseManifestJson(reader io.Reader) ([]*ManifestEntry, error) {
+	entries := []*ManifestEntry{}
+	if err := json.NewDecoder(reader).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func ParseManifestCsv(reader io.Reader) ([]*ManifestEntry, error) {
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no header row")
+	}
+
+	columns := make(map[string]int)
+	for index, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = index
+	}
+	for _, required := range []string{"output", "source"} {
+		if _, found := columns[required]; !found {
+			return nil, fmt.Errorf("no '%s' column", required)
+		}
+	}
+
+	cell := func(row []string, name string) string {
+		if index, found := columns[name]; found && index < len(row) {
+			return strings.TrimSpace(row[index])
+		}
+		return ""
+	}
+
+	// the first non-empty value for any column wins
+	fillIn := func(value *string, row []string, name string) {
+		if *value == "" {
+			*value = cell(row, name)
+		}
+	}
+
+	entries := []*ManifestEntry{}
+	byOutput := make(map[string]*ManifestEntry)
+
+	for _, row := range rows[1:] {
+		output := cell(row, "output")
+		if output == "" {
+			continue
+		}
+		entry, found := byOutput[output]
+		if !found {
+			entry = &ManifestEntry{Output: output}
+			byOutput[output] = entry
+			entries = append(entries, entry)
+		}
+		fillIn(&entry.Profile, row, "profile")
+		fillIn(&entry.Title, row, "title")
+		fillIn(&entry.Date, row, "date")
+		fillIn(&entry.Description, row, "description")
+		fillIn(&entry.Filters, row, "filters")
+
+		if source := cell(row, "source"); source != "" {
+			entry.Sources = append(entry.Sources, source)
+			entry.Chapters = append(entry.Chapters, cell(row, "chapter"))
+			entry.Trims = append(entry.Trims, cell(row, "trim"))
+		}
+	}
+
+	return entries, nil
+}
+
+func groupsFromManifestEntries(entries []*ManifestEntry, relativeTo string) ([]*Group, error) {
+	groups := []*Group{}
+
+	for index, entry := range entries {
+		if entry.Output == "" {
+			return nil, fmt.Errorf("manifest entry #%d has no output", index+1)
+		}
+		if len(entry.Sources) == 0 {
+			return nil, fmt.Errorf("manifest entry '%s' has no sources", entry.Output)
+		}
+		// outputs all go in the output root, which is the only directory we create
+		if strings.ContainsAny(entry.Output, `/\`) || entry.Output == "." || entry.Output == ".." {
+			return nil, fmt.Errorf("manifest entry '%s': the output must be a file name, without any directories", entry.Output)
+		}
+
+		profile, err := lookupProfile(entry.Profile)
+		if err != nil {
+			return nil, fmt.Errorf("manifest entry '%s': %s", entry.Output, err.Error())
+		}
+
+		name := strings.TrimSuffix(entry.Output, getFileExtension(entry.Output))
+		title := entry.Title
+		if title == "" {
+			title = name
+		}
+
+		group := &Group{
+			name:        name,
+			profile:     profile,
+			title:       title,
+			date:        entry.Date,
+			description: entry.Description,
+		}
+
+		if entry.Filters != "" {
+			if group.filters, err = ParseFilterChain(entry.Filters); err != nil {
+				return nil, fmt.Errorf("manifest entry '%s': %s", entry.Output, err.Error())
+			}
+		}
+
+		if len(entry.Chapters) > len(entry.Sources) {
+			return nil, fmt.Errorf("manifest entry '%s' has more chapters than sources", entry.Output)
+		}
+
+		if len(entry.Trims) > len(entry.Sources) {
+			return nil, fmt.Errorf("manifest entry '%s' has more trims than sources", entry.Output)
+		}
+
+		for sourceIndex, source := range entry.Sources {
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(relativeTo, source)
+			}
+			fileInfo, err := os.Stat(source)
+			if err != nil {
+				return nil, fmt.Errorf("manifest entry '%s': %s", entry.Output, err.Error())
+			}
+			if fileInfo.IsDir() {
+				return nil, fmt.Errorf("manifest entry '%s': %s is a directory", entry.Output, source)
+			}
+			chapter := ""
+			if sourceIndex < len(entry.Chapters) {
+				chapter = entry.Chapters[sourceIndex]
+			}
+			var segments []*Segment
+			if sourceIndex < len(entry.Trims) && entry.Trims[sourceIndex] != "" {
+				if segments, err = ParseSegments(entry.Trims[sourceIndex]); err != nil {
+					return nil, fmt.Errorf("manifest entry '%s': %s", entry.Output, err.Error())
+				}
+			}
+			group.files = append(group.files, &FileWithSize{source, fileInfo.Size(), fileInfo.ModTime(), chapter, segments})
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
@@ -25,6 +25,13 @@ type VideoInfo struct {
 
 func GetVideoInfoUsingFfProbe(path string) (VideoInfo, error) {
 
+	// the probe cache (see probecache.go) usually has the answer already
+	if media, err := ProbeMedia(path); err == nil {
+		if info, err := media.VideoInfo(); err == nil {
+			return info, nil
+		}
+	}
+
 	fullPath, err := filepath.Abs(path)
 
 	if err != nil {
@@ -32,8 +39,12 @@ func GetVideoInfoUsingFfProbe(path string) (VideoInfo, error) {
 	}
 
 	args := []string{`-hide_banner`, fullPath}
-	
-	output := invoke("ffprobe", args)
+
+	// ffprobe writes this sort of thing to stderr
+	_, output, err := probe(settings.ffprobePath, args)
+	if err != nil {
+		return VideoInfo{}, err
+	}
 	lines := strings.Split(output, "\n")
 
 	return ParseVideoInfoFromFfProbe(lines)
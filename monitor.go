@@ -40,6 +40,7 @@ func (m *Monitor) NotifyTaskBegins(task *Task) {
 	defer m.lock.Unlock()
 	m.addMessage(fmt.Sprintf("Running task %v", task.BriefString()))
 	m.activeTasks = append(m.activeTasks, task)
+	m.refreshGraph()
 }
 
 func (m *Monitor) NotifyTaskEnds(task *Task) {
@@ -63,6 +64,7 @@ func (m *Monitor) NotifyTaskEnds(task *Task) {
 
 			// rebuild the activeTasks list with the index'th element removed
 			m.activeTasks = append(m.activeTasks[:index], m.activeTasks[index+1:]...)
+			m.refreshGraph()
 			return
 		}
 	}
@@ -146,6 +148,15 @@ func (m *Monitor) tick(runTimeInSecond float64) {
 
 }
 
+// keeps the -graph file showing the live state of each task
+func (m *Monitor) refreshGraph() {
+	if settings.graphFile != "" {
+		if err := WriteGraph(settings.graphFile, m.allTasks); err != nil {
+			Log("Could not update %s: %s", settings.graphFile, err.Error())
+		}
+	}
+}
+
 func (m *Monitor) addMessage(message string) {
 	Log(message) // the permanent record
 
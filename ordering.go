@@ -0,0 +1,165 @@
+package varchive
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the members of a directory group are concatenated in an order chosen by settings.order:
+//
+//    lexical   - plain string comparison of the paths ("clip 10" comes before "clip 2")
+//    natural   - runs of digits compare by value ("clip 2" comes before "clip 10")
+//    mtime     - file modification time, oldest first
+//    creation  - the container's creation_time tag (needs ffprobe)
+//    timecode  - the embedded camera timecode (needs ffprobe)
+//
+// files without a creation time or timecode go after those that have one, in natural order.
+// Manifest groups are never re-ordered, the manifest order is the order.
+
+var orderings = []string{"lexical", "natural", "mtime", "creation", "timecode"}
+
+func OrderGroupMembers(group *Group) {
+	files := group.files
+
+	switch settings.order {
+	case "lexical":
+		sort.SliceStable(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	case "natural":
+		sort.SliceStable(files, func(i, j int) bool { return NaturalLess(files[i].path, files[j].path) })
+
+	case "mtime":
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].modTime.Equal(files[j].modTime) {
+				return NaturalLess(files[i].path, files[j].path)
+			}
+			return files[i].modTime.Before(files[j].modTime)
+		})
+
+	case "creation", "timecode":
+		prefetchMediaInfo(files)
+
+		keys := make(map[*FileWithSize]string)
+		for _, file := range files {
+			keys[file] = probeOrderingKey(file.path)
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			ki, kj := keys[files[i]], keys[files[j]]
+			if ki == kj {
+				return NaturalLess(files[i].path, files[j].path)
+			}
+			if ki == "" || kj == "" {
+				return kj == "" // the ones with no key go last
+			}
+			return ki < kj
+		})
+	}
+}
+
+// returns a string that sorts correctly against the keys of the other files
+// (or an empty string if there is nothing to go on)
+func probeOrderingKey(path string) string {
+	info, err := ProbeMedia(path)
+	if err != nil {
+		Log("Could not probe %s for ordering: %s", path, err.Error())
+		return ""
+	}
+
+	if settings.order == "creation" {
+		// ISO 8601 in UTC (which is what ffmpeg writes), so it sorts as a string
+		return info.FindTag("creation_time")
+	}
+
+	fps := 25.0
+	if video := info.VideoStream(); video != nil && video.Fps > 0 {
+		fps = video.Fps
+	}
+	seconds, err := ParseTimecode(info.FindTag("timecode"), fps)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%015.3f", seconds)
+}
+
+// HH:MM:SS:FF (or HH:MM:SS;FF for drop-frame) in seconds.
+// Drop-frame timecode (for 29.97 or 59.94 fps) counts as though there were 30 (or 60) frames
+// a second, and skips the first 2 (or 4) frame numbers of every minute except every tenth
+// minute to stay in step with the clock. Without taking them back out again a long recording
+// drifts by 3.6 seconds an hour
+func ParseTimecode(timecode string, fps float64) (float64, error) {
+	fields := strings.FieldsFunc(timecode, func(r rune) bool { return r == ':' || r == ';' || r == '.' })
+	if len(fields) != 4 {
+		return 0, fmt.Errorf("'%s' is not a timecode", timecode)
+	}
+
+	values := [4]int64{}
+	for index, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a timecode", timecode)
+		}
+		values[index] = int64(value)
+	}
+	hours, minutes, seconds, frames := values[0], values[1], values[2], values[3]
+
+	dropFrame := strings.ContainsAny(timecode, ";.")
+	if !dropFrame {
+		return float64(hours*3600+minutes*60+seconds) + float64(frames)/fps, nil
+	}
+
+	nominalFps := int64(math.Round(fps))
+	dropped := nominalFps / 15 // 2 at 29.97, 4 at 59.94
+	totalMinutes := hours*60 + minutes
+	frameNumber := (hours*3600+minutes*60+seconds)*nominalFps + frames - dropped*(totalMinutes-totalMinutes/10)
+
+	return float64(frameNumber) / fps, nil
+}
+
+// compares strings treating each run of digits as a number, so "sample 2" < "sample 10"
+func NaturalLess(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si := i
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			sj := j
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	return a < b
+}
+
+func logGroupOrder(group *Group) {
+	Log("%s will be made from:", group.OutputPath())
+	for index, file := range group.files {
+		Log("  %3d. %s", index+1, file.path)
+	}
+}
@@ -0,0 +1,201 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// in dry run mode we don't run anything, we describe what would be run: each group, the order
+// of its files, the geometry we'd end up with, every command line, and what the Estimator
+// thinks it will all cost
+
+func PrintPlan(tasks []*Task) {
+	var plan string
+
+	switch settings.plan {
+	case "dot":
+		plan = RenderDot(tasks)
+	case "mermaid":
+		plan = RenderMermaid(tasks)
+	default:
+		plan = RenderPlan(tasks, NewEstimator())
+	}
+
+	if settings.planFile == "" {
+		fmt.Print(plan)
+		return
+	}
+
+	if err := os.WriteFile(settings.planFile, []byte(plan), 0644); err != nil {
+		fatal(err.Error())
+	}
+	Log("Plan written to %s", settings.planFile)
+}
+
+func RenderPlan(tasks []*Task, estimator *Estimator) string {
+	var b strings.Builder
+
+	finals := []*Task{}
+	for _, task := range tasks {
+		if task.taskType == Concatenate {
+			finals = append(finals, task)
+		}
+	}
+
+	fmt.Fprintf(&b, "Plan: %d output(s), %d task(s)\n", len(finals), len(tasks))
+
+	for _, final := range finals {
+		group := final.group
+
+		fmt.Fprintf(&b, "\n%s\n", final.fileOut)
+		if group != nil {
+			fmt.Fprintf(&b, "  Profile: %v\n", group.profile)
+			if filters := group.FilterChain(); len(filters) > 0 {
+				fmt.Fprintf(&b, "  Filters: %s\n", filters)
+			}
+			switch settings.subtitles {
+			case "extract":
+				fmt.Fprintf(&b, "  Subtitles: extracted to %s\n", subtitlesSidecarPath(final.fileOut))
+			case "keep", "burn":
+				fmt.Fprintf(&b, "  Subtitles: %s\n", settings.subtitles)
+			}
+			if group.overwrite {
+				b.WriteString("  (replaces the existing file)\n")
+			}
+			b.WriteString("  Sources:\n")
+			for index, file := range group.files {
+				fmt.Fprintf(&b, "    %3d. %s (%s)\n         %s\n",
+					index+1, file.path, niceSize(file.size), plannedGeometry(file, group.profile))
+				if settings.deinterlace == "auto" {
+					fmt.Fprintf(&b, "         interlaced: %s\n", interlacingOf(file))
+				}
+				if crop := cropFor(file, group); crop != nil {
+					fmt.Fprintf(&b, "         crop: %s\n", crop)
+				}
+			}
+		}
+
+		b.WriteString("  Tasks:\n")
+		for _, task := range taskGraph(final) {
+			fmt.Fprintf(&b, "    %s\n", task.BriefString())
+			for _, command := range plannedCommands(task) {
+				fmt.Fprintf(&b, "        %s\n", command)
+			}
+		}
+	}
+
+	if len(outputsInTheWay) > 0 {
+		fmt.Fprintf(&b, "\n%d output(s) exist, and a real run would refuse to start (see -onCollision):\n", len(outputsInTheWay))
+		for _, output := range outputsInTheWay {
+			fmt.Fprintf(&b, "  %s\n", output)
+		}
+	}
+
+	if !scanReport.IsEmpty() {
+		fmt.Fprintf(&b, "\n%s\n", scanReport)
+	}
+
+	fmt.Fprintf(&b, "\nEstimated run time: %s (with up to %d tasks in parallel)\n",
+		niceTime(estimator.EstimateRemainingRunTime(tasks)), settings.maxParallelTasks)
+	fmt.Fprintf(&b, "Estimated output size: %s\n", niceSize(int64(estimator.EstimateOutputSize(tasks))))
+
+	return b.String()
+}
+
+func plannedCommands(task *Task) []string {
+	commands := []string{}
+
+	switch task.taskType {
+	case Transcode:
+		commands = append(commands, commandLine(transcodeCommand(task)))
+
+	case FixAudio:
+		if settings.loudnorm {
+			commands = append(commands, commandLine(settings.ffmpegPath, loudnormMeasureArgs(task)))
+		}
+		if settings.fixAudioMode == "single" {
+			commands = append(commands, commandLine(settings.ffmpegPath, fixAudioSinglePassArgs(task, nil)))
+		} else {
+			for _, args := range fixAudioArgs(task, "<video stream>", "<audio stream>", nil) {
+				commands = append(commands, commandLine(settings.ffmpegPath, args))
+			}
+		}
+
+	case Concatenate:
+		commands = append(commands, commandLine(settings.ffmpegPath, concatenateArgs(task, "<list of parts>", "<chapters and tags>")))
+		if settings.subtitles == "extract" {
+			commands = append(commands, plannedSubtitleExtraction(task)...)
+		}
+	}
+
+	return commands
+}
+
+// one extraction for each source that has subtitles to give
+func plannedSubtitleExtraction(task *Task) []string {
+	commands := []string{}
+	seen := make(map[string]bool)
+	for _, dependee := range task.dependsOn {
+		if dependee.source == nil || seen[dependee.source.path] {
+			continue
+		}
+		seen[dependee.source.path] = true
+		media, err := ProbeMedia(dependee.source.path)
+		if err != nil {
+			continue
+		}
+		if args, err := subtitleExtractArgs(dependee.source.path, media, "<subtitles>"); err == nil {
+			commands = append(commands, commandLine(settings.ffmpegPath, args))
+		}
+	}
+	return commands
+}
+
+// source geometry -> output geometry, as far as we can tell without running HandBrake
+func plannedGeometry(file *FileWithSize, profile *Profile) string {
+	media, err := ProbeMedia(file.path)
+	if err != nil {
+		return fmt.Sprintf("geometry unknown (%s)", err.Error())
+	}
+	info, err := media.VideoInfo()
+	if err != nil {
+		return fmt.Sprintf("geometry unknown (%s)", err.Error())
+	}
+
+	width, height := info.Width, info.Height
+	requestedWidth, widthErr := strconv.ParseInt(profile.width, 10, 64)
+	requestedHeight, heightErr := strconv.ParseInt(profile.height, 10, 64)
+
+	switch {
+	case widthErr == nil && heightErr == nil:
+		width, height = requestedWidth, requestedHeight
+	case widthErr == nil && info.Width > 0:
+		width, height = requestedWidth, evenly(requestedWidth*info.Height/info.Width)
+	case heightErr == nil && info.Height > 0:
+		width, height = evenly(requestedHeight*info.Width/info.Height), requestedHeight
+	}
+
+	fps := fmt.Sprintf("%.2f", info.Fps)
+	if profile.fps != "" {
+		fps = profile.fps
+	}
+
+	return fmt.Sprintf("%dx%d @ %.2f fps -> %dx%d @ %s fps", info.Width, info.Height, info.Fps, width, height, fps)
+}
+
+func evenly(n int64) int64 {
+	return n - n%2
+}
+
+func commandLine(command string, args []string) string {
+	quoted := []string{command}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"\\$&;|<>()*?[]") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,222 @@
+package varchive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is the (useful part of the) output of
+//
+//    ffprobe -print_format json -show_format -show_streams <file>
+//
+// which is a lot less fragile to pick apart than the human readable output (see metadata.go)
+
+type MediaInfo struct {
+	Duration float64
+	Tags     map[string]string // container level, keys are lower-cased
+	Streams  []StreamInfo
+}
+
+type StreamInfo struct {
+	Index       int
+	CodecType   string // "video", "audio", "subtitle", "data"...
+	CodecName   string
+	Width       int64
+	Height      int64
+	Fps         float64
+	Tbr         float64
+	FieldOrder  string
+	Captions    bool // EIA-608 closed captions in the video
+	Channels    int
+	SampleRate  int
+	Duration    float64
+	Language    string
+	Rotation    float64
+	AttachedPic bool
+	Default     bool
+	Tags        map[string]string // keys are lower-cased
+}
+
+// source files are looked up in the cache first (see probecache.go)
+func ProbeMedia(path string) (MediaInfo, error) {
+	cache := getProbeCache()
+
+	if media, found := cache.lookup(path); found {
+		return media, nil
+	}
+
+	media, err := probeMediaUncached(path)
+	if err == nil {
+		cache.store(path, media)
+	}
+	return media, err
+}
+
+// for temporary files, which would only clutter up the cache
+func probeMediaUncached(path string) (MediaInfo, error) {
+	fullPath, err := filepath.Abs(path)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	args := []string{`-v`, `error`, `-print_format`, `json`, `-show_format`, `-show_streams`, fullPath}
+
+	output, _, err := probe(settings.ffprobePath, args)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	return ParseMediaInfo([]byte(output))
+}
+
+type ffprobeJson struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index        int               `json:"index"`
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int64             `json:"width"`
+		Height       int64             `json:"height"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		FieldOrder   string            `json:"field_order"`
+		Captions     int               `json:"closed_captions"`
+		Channels     int               `json:"channels"`
+		SampleRate   string            `json:"sample_rate"`
+		Duration     string            `json:"duration"`
+		Disposition  map[string]int    `json:"disposition"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			SideDataType string  `json:"side_data_type"`
+			Rotation     float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+func ParseMediaInfo(output []byte) (MediaInfo, error) {
+	raw := ffprobeJson{}
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return MediaInfo{}, fmt.Errorf("could not parse ffprobe output: %s", err.Error())
+	}
+
+	info := MediaInfo{
+		Duration: parseFloatOrZero(raw.Format.Duration),
+		Tags:     lowerCaseKeys(raw.Format.Tags),
+	}
+
+	for _, s := range raw.Streams {
+		stream := StreamInfo{
+			Index:       s.Index,
+			CodecType:   s.CodecType,
+			CodecName:   s.CodecName,
+			Width:       s.Width,
+			Height:      s.Height,
+			Fps:         parseRational(s.AvgFrameRate),
+			Tbr:         parseRational(s.RFrameRate),
+			FieldOrder:  s.FieldOrder,
+			Captions:    s.Captions == 1,
+			Channels:    s.Channels,
+			SampleRate:  int(parseFloatOrZero(s.SampleRate)),
+			Duration:    parseFloatOrZero(s.Duration),
+			AttachedPic: s.Disposition["attached_pic"] == 1,
+			Default:     s.Disposition["default"] == 1,
+			Tags:        lowerCaseKeys(s.Tags),
+		}
+		if stream.Fps == 0 {
+			stream.Fps = parseRational(s.RFrameRate)
+		}
+		stream.Language = stream.Tags["language"]
+
+		// older ffmpegs use a 'rotate' tag, newer ones a display matrix
+		if rotate, found := stream.Tags["rotate"]; found {
+			stream.Rotation = parseFloatOrZero(rotate)
+		}
+		for _, sideData := range s.SideDataList {
+			if sideData.SideDataType == "Display Matrix" && sideData.Rotation != 0 {
+				stream.Rotation = sideData.Rotation
+			}
+		}
+
+		info.Streams = append(info.Streams, stream)
+	}
+
+	return info, nil
+}
+
+// the first video stream that is actually video (i.e. not cover art), or nil
+func (m *MediaInfo) VideoStream() *StreamInfo {
+	for index := range m.Streams {
+		if m.Streams[index].CodecType == "video" && !m.Streams[index].AttachedPic {
+			return &m.Streams[index]
+		}
+	}
+	return nil
+}
+
+// the same shape of answer as GetVideoInfoUsingFfProbe
+func (m *MediaInfo) VideoInfo() (VideoInfo, error) {
+	video := m.VideoStream()
+	if video == nil {
+		return VideoInfo{}, errors.New("no video stream")
+	}
+	return VideoInfo{video.Width, video.Height, video.Fps, video.Tbr}, nil
+}
+
+func (m *MediaInfo) StreamsOfType(codecType string) []*StreamInfo {
+	streams := []*StreamInfo{}
+	for index := range m.Streams {
+		if m.Streams[index].CodecType == codecType {
+			streams = append(streams, &m.Streams[index])
+		}
+	}
+	return streams
+}
+
+// looks for a tag on the container first, then on each stream in turn
+func (m *MediaInfo) FindTag(key string) string {
+	if value, found := m.Tags[key]; found {
+		return value
+	}
+	for _, stream := range m.Streams {
+		if value, found := stream.Tags[key]; found {
+			return value
+		}
+	}
+	return ""
+}
+
+func lowerCaseKeys(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for key, value := range in {
+		out[strings.ToLower(key)] = value
+	}
+	return out
+}
+
+func parseFloatOrZero(text string) float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// e.g. "30000/1001" -> 29.97
+func parseRational(text string) float64 {
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 {
+		return parseFloatOrZero(text)
+	}
+	numerator, denominator := parseFloatOrZero(parts[0]), parseFloatOrZero(parts[1])
+	if denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
@@ -0,0 +1,173 @@
+package varchive
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ffprobe is slow on a NAS with thousands of files, so its answers are kept, keyed by
+// path, size and modification time, both in memory and (by default) on disk between runs:
+//
+//    ~/.cache/varchive/probe-cache.json
+//
+// prefetchMediaInfo warms the cache using a bounded pool of ffprobes. Whatever else gets
+// probed along the way is saved when GetBusy is done.
+
+type ProbeCache struct {
+	lock    sync.Mutex
+	path    string // empty if the cache is in memory only
+	dirty   bool
+	Entries map[string]*ProbeCacheEntry `json:"entries"`
+}
+
+type ProbeCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime string    `json:"modTime"`
+	Media   MediaInfo `json:"media"`
+}
+
+var probeCache *ProbeCache
+var probeCacheOnce sync.Once
+
+func getProbeCache() *ProbeCache {
+	probeCacheOnce.Do(func() {
+		probeCache = &ProbeCache{Entries: make(map[string]*ProbeCacheEntry)}
+
+		if !settings.useProbeCache {
+			return
+		}
+
+		probeCache.path = settings.probeCachePath
+		if probeCache.path == "" {
+			directory, err := os.UserCacheDir()
+			if err != nil {
+				Log("No probe cache on disk: %s", err.Error())
+				return
+			}
+			probeCache.path = filepath.Join(directory, "varchive", "probe-cache.json")
+		}
+
+		if content, err := os.ReadFile(probeCache.path); err == nil {
+			if err := json.Unmarshal(content, probeCache); err != nil {
+				Log("Ignoring the probe cache %s: %s", probeCache.path, err.Error())
+				probeCache.Entries = make(map[string]*ProbeCacheEntry)
+			}
+		}
+	})
+	return probeCache
+}
+
+func probeCacheKey(path string) (string, int64, string, error) {
+	fullPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", 0, "", err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return fullPath, info.Size(), info.ModTime().Format("2006-01-02T15:04:05.999999999Z07:00"), nil
+}
+
+func (c *ProbeCache) lookup(path string) (MediaInfo, bool) {
+	key, size, modTime, err := probeCacheKey(path)
+	if err != nil {
+		return MediaInfo{}, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, found := c.Entries[key]
+	if !found || entry.Size != size || entry.ModTime != modTime {
+		return MediaInfo{}, false
+	}
+	return entry.Media, true
+}
+
+func (c *ProbeCache) store(path string, media MediaInfo) {
+	key, size, modTime, err := probeCacheKey(path)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Entries[key] = &ProbeCacheEntry{size, modTime, media}
+	c.dirty = true
+}
+
+// not in dry run mode, which leaves nothing behind, not even a cache
+func (c *ProbeCache) Save() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.path == "" || !c.dirty || settings.dryRun {
+		return
+	}
+
+	content, err := json.Marshal(c)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(c.path+".tmp", content, 0644)
+	}
+	if err == nil {
+		err = os.Rename(c.path+".tmp", c.path)
+	}
+	if err != nil {
+		Log("Could not save the probe cache %s: %s", c.path, err.Error())
+		return
+	}
+	c.dirty = false
+}
+
+// probes any files not already in the cache, several at a time
+func prefetchMediaInfo(files []*FileWithSize) {
+	cache := getProbeCache()
+
+	forEachFileInParallel(files, func(file *FileWithSize) {
+		if _, found := cache.lookup(file.path); !found {
+			if _, err := ProbeMedia(file.path); err != nil && settings.verbose {
+				Log("Could not probe %s: %s", file.path, err.Error())
+			}
+		}
+	})
+
+	cache.Save()
+}
+
+// runs work on every file, with at most settings.probeWorkers at a time
+func forEachFileInParallel(files []*FileWithSize, work func(*FileWithSize)) {
+	queue := make(chan *FileWithSize)
+	waitGroup := new(sync.WaitGroup)
+
+	for worker := 0; worker < settings.probeWorkers; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for file := range queue {
+				work(file)
+			}
+		}()
+	}
+
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+
+	waitGroup.Wait()
+}
+
+func allFilesInGroups(groups []*Group) []*FileWithSize {
+	files := []*FileWithSize{}
+	for _, group := range groups {
+		files = append(files, group.files...)
+	}
+	return files
+}
@@ -0,0 +1,73 @@
+package varchive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// a Profile is one 'flavour' of transcode (see TODO item 005)
+//
+// The "default" profile is built from the command line settings, the others
+// only borrow the geometry settings (if any) from the command line
+
+type Profile struct {
+	name    string
+	encoder string
+	quality int
+	width   string
+	height  string
+	fps     string
+	filters FilterChain // see filterchain.go
+}
+
+// HandBrake's names for the encoders -> ffmpeg's
+var ffmpegEncoders = map[string]string{
+	"x265": "libx265",
+	"x264": "libx264",
+}
+
+func builtInProfiles() map[string]*Profile {
+	return map[string]*Profile{
+		"default": {"default", "x265", settings.quality, settings.width, settings.height, settings.fps, settings.filterChain},
+		"h264":    {"h264", "x264", settings.quality, settings.width, settings.height, settings.fps, settings.filterChain},
+		"preview": {"preview", "x264", 28, "640", "", settings.fps, settings.filterChain},
+	}
+}
+
+func profileNames() string {
+	names := []string{}
+	for name := range builtInProfiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// an empty name means "whatever was chosen on the command line"
+func lookupProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = settings.profile
+	}
+	if profile, found := builtInProfiles()[name]; found {
+		return profile, nil
+	}
+	return nil, fmt.Errorf("unknown profile '%s' (known profiles are: %s)", name, profileNames())
+}
+
+// the width and height, as an ffmpeg scale filter (keeping the aspect ratio if only one is given)
+func (p *Profile) ffmpegScale() string {
+	switch {
+	case p.width != "" && p.height != "":
+		return fmt.Sprintf("scale=%s:%s", p.width, p.height)
+	case p.width != "":
+		return fmt.Sprintf("scale=%s:-2", p.width)
+	case p.height != "":
+		return fmt.Sprintf("scale=-2:%s", p.height)
+	}
+	return ""
+}
+
+func (p *Profile) String() string {
+	return fmt.Sprintf("%s (%s, quality %d)", p.name, p.encoder, p.quality)
+}
@@ -0,0 +1,174 @@
+package varchive
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+// for every final output there is a sidecar saying exactly how it was made:
+//
+//    <output>.varchive.json
+//
+// i.e. the sources (with checksums and geometry), the settings (as the catalogue compares
+// them, see catalogue.go), every task in the graph that led to the output (with the command
+// lines that were run), the tool versions and the timings
+
+type Provenance struct {
+	Output           string             `json:"output"`
+	OutputSize       int64              `json:"outputSize"`
+	OutputChecksum   string             `json:"outputSha256"`
+	VarchiveVersion  string             `json:"varchiveVersion"`
+	Host             string             `json:"host"`
+	Profile          string             `json:"profile"`
+	Settings         string             `json:"settings"`
+	Tools            map[string]string  `json:"tools"`
+	Sources          []ProvenanceSource `json:"sources"`
+	Tasks            []ProvenanceTask   `json:"tasks"`
+	RunTimeInSeconds float64            `json:"totalRunTimeInSeconds"`
+}
+
+type ProvenanceSource struct {
+	Path      string     `json:"path"`
+	Size      int64      `json:"size"`
+	ModTime   string     `json:"modTime"`
+	Checksum  string     `json:"sha256"`
+	VideoInfo *VideoInfo `json:"videoInfo,omitempty"`
+}
+
+type ProvenanceTask struct {
+	Id               int        `json:"id"`
+	Type             string     `json:"type"`
+	FileIn           string     `json:"fileIn,omitempty"`
+	FileOut          string     `json:"fileOut"`
+	DependsOn        []int      `json:"dependsOn,omitempty"`
+	Commands         [][]string `json:"commands"`
+	Started          string     `json:"started"`
+	RunTimeInSeconds float64    `json:"runTimeInSeconds"`
+}
+
+const isoTimeLayout = "2006-01-02T15:04:05Z07:00"
+
+var toolVersions map[string]string
+var toolVersionsOnce sync.Once
+
+// just the first line of each tool's idea of its version
+func getToolVersions() map[string]string {
+	toolVersionsOnce.Do(func() {
+		toolVersions = make(map[string]string)
+		for _, tool := range [][]string{
+			{"HandBrakeCLI", settings.handbrakePath, "--version"},
+			{"ffmpeg", settings.ffmpegPath, "-version"},
+			{"ffprobe", settings.ffprobePath, "-version"}} {
+			stdout, stderr, err := probe(tool[1], tool[2:])
+			version := strings.TrimSpace(stdout + "\n" + stderr)
+			if err != nil {
+				version = err.Error()
+			}
+			for _, line := range strings.Split(version, "\n") {
+				// HandBrake chats about its hardware detection first
+				if strings.Contains(strings.ToLower(line), "version") || strings.HasPrefix(line, tool[0]) {
+					version = line
+					break
+				}
+			}
+			toolVersions[tool[0]] = strings.TrimSpace(version)
+		}
+	})
+	return toolVersions
+}
+
+func NewProvenance(task *Task) *Provenance {
+	p := &Provenance{
+		Output:          task.fileOut,
+		VarchiveVersion: Version,
+		Tools:           getToolVersions(),
+	}
+
+	p.Host, _ = os.Hostname()
+	if task.profile != nil {
+		p.Profile = task.profile.String()
+	}
+
+	if info, err := os.Stat(task.fileOut); err == nil {
+		p.OutputSize = info.Size()
+	}
+	if checksum, err := fileChecksum(task.fileOut); err == nil {
+		p.OutputChecksum = checksum
+	}
+
+	if task.group != nil {
+		p.Settings = SettingsFingerprint(task.group)
+		for _, file := range task.group.files {
+			source := ProvenanceSource{
+				Path:    file.path,
+				Size:    file.size,
+				ModTime: file.modTime.Format(isoTimeLayout),
+			}
+			if checksum, err := sourceChecksum(file); err == nil {
+				source.Checksum = checksum
+			}
+			if media, err := ProbeMedia(file.path); err == nil {
+				if info, err := media.VideoInfo(); err == nil {
+					source.VideoInfo = &info
+				}
+			}
+			p.Sources = append(p.Sources, source)
+		}
+	}
+
+	for _, t := range taskGraph(task) {
+		entry := ProvenanceTask{
+			Id:               t.id,
+			Type:             t.TaskType(),
+			FileIn:           t.fileIn,
+			FileOut:          t.fileOut,
+			Commands:         t.commands,
+			Started:          t.startTimestamp.Format(isoTimeLayout),
+			RunTimeInSeconds: t.runTimeInSeconds,
+		}
+		for _, d := range t.dependsOn {
+			entry.DependsOn = append(entry.DependsOn, d.id)
+		}
+		p.Tasks = append(p.Tasks, entry)
+		p.RunTimeInSeconds += t.runTimeInSeconds
+	}
+
+	return p
+}
+
+// the task and everything it depends on, dependees first
+func taskGraph(task *Task) []*Task {
+	result := []*Task{}
+	seen := make(map[*Task]bool)
+
+	var visit func(t *Task)
+	visit = func(t *Task) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for _, d := range t.dependsOn {
+			visit(d)
+		}
+		result = append(result, t)
+	}
+	visit(task)
+
+	return result
+}
+
+func writeProvenance(task *Task) {
+	path := task.fileOut + ".varchive.json"
+
+	content, err := json.MarshalIndent(NewProvenance(task), "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, content, 0644)
+	}
+	if err != nil {
+		Log("Could not write %s: %s", path, err.Error())
+	} else if settings.verbose {
+		Log("Wrote provenance to %s", path)
+	}
+}
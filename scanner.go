@@ -4,24 +4,122 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type FileWithSize struct {
-	path string
-	size int64
+	path    string
+	size    int64
+	modTime Timestamp
+	chapter string // optional, the chapter title to use in the concatenated output
+
+	segments []*Segment // optional, the parts to keep (see segments.go)
 }
 
 type FilesWithSize []*FileWithSize
 
+func (f *FileWithSize) Path() string {
+	return f.path
+}
+
+// everything ScanPaths found but decided not to use, and why, plus anything it could not read
+type ScanReport struct {
+	skipped []skippedFile
+	errors  []skippedFile
+}
+
+type skippedFile struct {
+	path   string
+	reason string
+}
+
+var scanReport = &ScanReport{}
+
+func (r *ScanReport) skip(path string, reason string) {
+	r.skipped = append(r.skipped, skippedFile{path, reason})
+}
+
+func (r *ScanReport) fail(path string, err error) {
+	r.errors = append(r.errors, skippedFile{path, err.Error()})
+}
+
+// Reasons gives why each path was left out, by path (errors start "error: ")
+func (r *ScanReport) Reasons() map[string]string {
+	reasons := make(map[string]string)
+	for _, skipped := range r.skipped {
+		reasons[skipped.path] = skipped.reason
+	}
+	for _, failed := range r.errors {
+		reasons[failed.path] = "error: " + failed.reason
+	}
+	return reasons
+}
+
+func (r *ScanReport) IsEmpty() bool {
+	return len(r.skipped) == 0 && len(r.errors) == 0
+}
+
+func (r *ScanReport) String() string {
+	if r.IsEmpty() {
+		return "No files were skipped"
+	}
+	text := fmt.Sprintf("%d file(s) skipped, %d could not be read", len(r.skipped), len(r.errors))
+	for _, skipped := range r.skipped {
+		text += fmt.Sprintf("\n  %s (%s)", skipped.path, skipped.reason)
+	}
+	for _, failed := range r.errors {
+		text += fmt.Sprintf("\n  %s (error: %s)", failed.path, failed.reason)
+	}
+	return text
+}
+
+// each path on the command line becomes a group: a directory contributes the files directly
+// inside it, and a file is a group all on its own.
+//
+// Within a directory:
+//    - subdirectories are not handled (and are fatal), other than hidden ones
+//    - hidden files and directories (.whatever, and the @eaDir a Synology NAS leaves
+//      everywhere) are skipped unless settings.includeHidden
+//    - symbolic links are skipped unless settings.followSymlinks, in which case links
+//      to files are used, links to directories are skipped, and a file reached more than
+//      once (via links, or links to links) is only used the first time
+//    - anything that can't be read is recorded in the scan report, and the scan carries on
+
+// the settings the scan goes by
+type ScanOptions struct {
+	IncludeHidden  bool
+	FollowSymlinks bool
+	Filter         *FileFilter
+}
+
 func ScanPaths() map[string]FilesWithSize {
 
 	if settings.verbose {
 		Log("Scanning paths")
 	}
 
+	options := ScanOptions{settings.includeHidden, settings.followSymlinks, newFileFilterFromSettings()}
+
+	var pathsAndFiles map[string]FilesWithSize
+	pathsAndFiles, scanReport = ScanPathsWith(settings.paths, options)
+
+	if settings.sniff {
+		sniffOutNonVideo(pathsAndFiles)
+	}
+
+	if !scanReport.IsEmpty() || settings.verbose {
+		Log(scanReport.String())
+	}
+
+	return pathsAndFiles
+}
+
+func ScanPathsWith(paths []string, options ScanOptions) (map[string]FilesWithSize, *ScanReport) {
 	pathsAndFiles := make(map[string]FilesWithSize)
+	report := &ScanReport{}
+	seen := make(map[string]string) // real path -> the path we first saw it as
 
-	for _, path := range settings.paths {
+	for _, path := range paths {
 
 		if settings.verbose { 
 			Log("Scanning %s....", path)
@@ -38,30 +136,126 @@ func ScanPaths() map[string]FilesWithSize {
 			if settings.verbose { 
 				Log("%s is a directory", path)
 			}
-			err := filepath.Walk(path, func(walkedPath string, fileInfo os.FileInfo, err error) error {
-				if err == nil {
-					if walkedPath != path { // the path itself is included in the results of Walk(path,...)
-						if fileInfo.IsDir() {
-							fatal(fmt.Sprintf("Recursive directories are not handled (%v)", walkedPath))
-						} else {
-							filesForPath = append(filesForPath, &FileWithSize{walkedPath, fileInfo.Size()})
-						}
-					} else {
-						return err
+			filepath.Walk(path, func(walkedPath string, fileInfo os.FileInfo, err error) error {
+				if err != nil {
+					report.fail(walkedPath, err)
+					return nil
+				}
+				if walkedPath == path { // the path itself is included in the results of Walk(path,...)
+					return nil
+				}
+				if fileInfo.IsDir() {
+					if !options.IncludeHidden && isHidden(fileInfo.Name()) {
+						report.skip(walkedPath, "hidden directory")
+						return filepath.SkipDir
 					}
+					fatal(fmt.Sprintf("Recursive directories are not handled (%v)", walkedPath))
+				}
+				if file := considerFile(walkedPath, fileInfo, options, true, seen, report); file != nil {
+					filesForPath = append(filesForPath, file)
 				}
 				return nil
 			})
-			if err != nil {
-				panic(err)
-			}
 		} else {
-			Log("%v is a file and will be ignored", path)
+			if settings.verbose {
+				Log("%s is a file, and will be a group of its own", path)
+			}
+			if file := considerFile(path, fileInfo, options, false, seen, report); file != nil {
+				filesForPath = append(filesForPath, file)
+			}
 		}
 
 		pathsAndFiles[path] = filesForPath
 	}
 
-	return pathsAndFiles
+	return pathsAndFiles, report
+}
+
+// returns nil (having updated the scan report) if the file is not to be used. A file that was
+// asked for by name, rather than found in a directory, is neither hidden nor filtered
+func considerFile(path string, fileInfo os.FileInfo, options ScanOptions, inDirectory bool, seen map[string]string, report *ScanReport) *FileWithSize {
+	name := fileInfo.Name()
+
+	if name == ignoreFileName {
+		return nil // not a candidate, and not worth mentioning
+	}
+
+	if inDirectory && !options.IncludeHidden && isHidden(name) {
+		report.skip(path, "hidden")
+		return nil
+	}
+
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		if !options.FollowSymlinks {
+			report.skip(path, "symbolic link")
+			return nil
+		}
+		target, err := os.Stat(path)
+		if err != nil {
+			report.fail(path, err)
+			return nil
+		}
+		if target.IsDir() {
+			report.skip(path, "symbolic link to a directory")
+			return nil
+		}
+		fileInfo = target
+	}
+
+	if !fileInfo.Mode().IsRegular() {
+		report.skip(path, "not a regular file")
+		return nil
+	}
+
+	if inDirectory && options.Filter != nil {
+		if reason := options.Filter.Check(path); reason != "" {
+			report.skip(path, reason)
+			return nil
+		}
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		report.fail(path, err) // including symbolic link loops
+		return nil
+	}
+	if first, found := seen[realPath]; found {
+		report.skip(path, fmt.Sprintf("same file as %s", first))
+		return nil
+	}
+	seen[realPath] = path
+
+	// better to find out now than half way through a transcode
+	file, err := os.Open(path)
+	if err != nil {
+		report.fail(path, err)
+		return nil
+	}
+	file.Close()
+
+	return &FileWithSize{path, fileInfo.Size(), fileInfo.ModTime(), "", nil}
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "@eaDir"
 }
 
+func sniffOutNonVideo(pathsAndFiles map[string]FilesWithSize) {
+	all := FilesWithSize{}
+	for _, files := range pathsAndFiles {
+		all = append(all, files...)
+	}
+	prefetchMediaInfo(all)
+
+	for path, files := range pathsAndFiles {
+		kept := FilesWithSize{}
+		for _, file := range files {
+			if reason := sniffForVideo(file.path); reason != "" {
+				scanReport.skip(file.path, reason)
+			} else {
+				kept = append(kept, file)
+			}
+		}
+		pathsAndFiles[path] = kept
+	}
+}
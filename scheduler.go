@@ -40,6 +40,8 @@ func ScheduleTasks(timer Timer, tasks []*Task) {
 
 				m.NotifyTaskEnds(task)
 
+				FinishTask(task)
+
 				<-guard // consume an item from the channel, allowing another go routine to start
 			}()
 		} else {
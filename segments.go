@@ -0,0 +1,391 @@
+package varchive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per source trims: the parts of a source worth keeping, each of which becomes a Transcode
+// task of its own (the parts are concatenated like any others). Written as start-end, with
+// either left out to mean the start or end of the source, several separated by commas,
+// semicolons or new lines:
+//
+//    0:04-1:30, 2:00-
+//
+// Times are seconds (4.5), [h:]m:s (1:02:03) or Go durations (1m30s).
+// The first of these that has something to say about a source wins:
+//
+//   - -trim 'glob=segments' on the command line, the glob matching the path or the file name
+//   - the 'trims' in the manifest entry (or the 'trim' column)
+//   - a sidecar file next to the source, named after it with .trim on the end
+//     ('#' starts a comment)
+//
+// A source with trims ignores any trim in the filter chain. -suggestTrims looks for black
+// and silence at either end of each source, and suggests trims in the same form
+
+type Segment struct {
+	Start float64 // seconds into the source
+	End   float64 // 0 means the end of the source
+
+	part string // e.g. "2 of 3", for the chapter title
+}
+
+const trimSidecarExtension = ".trim"
+
+func (s *Segment) String() string {
+	text := ""
+	if s.Start > 0 {
+		text = fmt.Sprintf("%g", s.Start)
+	}
+	text += "-"
+	if s.End > 0 {
+		text += fmt.Sprintf("%g", s.End)
+	}
+	return text
+}
+
+// HandBrake's stop point is relative to its start point
+func (s *Segment) HandBrakeArgs() []string {
+	args := []string{}
+	if s.Start > 0 {
+		args = append(args, "--start-at", fmt.Sprintf("seconds:%g", s.Start))
+	}
+	if s.End > 0 {
+		args = append(args, "--stop-at", fmt.Sprintf("seconds:%g", s.End-s.Start))
+	}
+	return args
+}
+
+// seeking in the input is much quicker than trimming in the filtergraph
+func (s *Segment) FfmpegInputArgs() []string {
+	args := []string{}
+	if s.Start > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%g", s.Start))
+	}
+	if s.End > 0 {
+		args = append(args, "-to", fmt.Sprintf("%g", s.End))
+	}
+	return args
+}
+
+func parseSegment(text string) (*Segment, error) {
+	index := strings.Index(text, "-")
+	if index < 0 {
+		return nil, fmt.Errorf("'%s' should be start-end, e.g. 4s-1m30s (either may be left out)", text)
+	}
+	start, err := parseTime(strings.TrimSpace(text[:index]))
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTime(strings.TrimSpace(text[index+1:]))
+	if err != nil {
+		return nil, err
+	}
+	if end != 0 && end <= start {
+		return nil, fmt.Errorf("'%s' ends before it starts", text)
+	}
+	return &Segment{Start: start, End: end}, nil
+}
+
+var segmentSeparators = regexp.MustCompile(`[,;]`)
+
+func ParseSegments(text string) ([]*Segment, error) {
+	segments := []*Segment{}
+
+	for _, line := range strings.Split(text, "\n") {
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+		for _, item := range segmentSeparators.Split(line, -1) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			segment, err := parseSegment(item)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+	}
+
+	for index := 1; index < len(segments); index++ {
+		previous := segments[index-1]
+		if previous.End == 0 || segments[index].Start < previous.End {
+			return nil, fmt.Errorf("'%s' overlaps '%s' (segments have to be in order)", segments[index], previous)
+		}
+	}
+
+	return segments, nil
+}
+
+// plain seconds (12.5), [h:]m:s (1:02:03.5) or a Go duration (1m30s)
+func parseTime(text string) (float64, error) {
+	if text == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseFloat(text, 64); err == nil {
+		return seconds, nil
+	}
+	if strings.Contains(text, ":") {
+		total := 0.0
+		for _, part := range strings.Split(text, ":") {
+			value, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return 0, fmt.Errorf("'%s' is not a time", text)
+			}
+			total = total*60 + value
+		}
+		return total, nil
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time", text)
+	}
+	return duration.Seconds(), nil
+}
+
+// the -trim options, already checked by ParseArguments
+func trimsFromCommandLine(file *FileWithSize) ([]*Segment, bool) {
+	for _, trim := range settings.trims {
+		index := strings.LastIndex(trim, "=")
+		glob, segments := trim[:index], trim[index+1:]
+		matchesPath, _ := filepath.Match(glob, file.path)
+		matchesName, _ := filepath.Match(glob, filepath.Base(file.path))
+		if matchesPath || matchesName {
+			parsed, _ := ParseSegments(segments)
+			return parsed, true
+		}
+	}
+	return nil, false
+}
+
+func trimsFromSidecar(file *FileWithSize) ([]*Segment, bool) {
+	content, err := os.ReadFile(file.path + trimSidecarExtension)
+	if err != nil {
+		return nil, false
+	}
+	segments, err := ParseSegments(string(content))
+	if err != nil {
+		Log("Ignoring %s: %s", file.path+trimSidecarExtension, err.Error())
+		return nil, false
+	}
+	return segments, true
+}
+
+// the parts of the source to keep, or nil for all of it
+func segmentsFor(file *FileWithSize) []*Segment {
+	segments, found := trimsFromCommandLine(file)
+	if !found && file.segments != nil {
+		segments, found = file.segments, true
+	}
+	if !found {
+		segments, found = trimsFromSidecar(file)
+	}
+	if !found || len(segments) == 0 {
+		return nil
+	}
+
+	// a fresh copy each time, as the tasks hang on to them
+	copies := []*Segment{}
+	for index, segment := range segments {
+		kept := *segment
+		if len(segments) > 1 {
+			kept.part = fmt.Sprintf("%d of %d", index+1, len(segments))
+		}
+		copies = append(copies, &kept)
+	}
+	return copies
+}
+
+// the segments for the Transcode tasks: any that start at or after the end of the
+// source would give HandBrake nothing to do, so they are dropped with a warning,
+// and a source with nothing left is an error
+func segmentsToTranscode(file *FileWithSize) []*Segment {
+	segments := segmentsFor(file)
+	if segments == nil {
+		return nil
+	}
+	media, err := ProbeMedia(file.path)
+	if err != nil || media.Duration <= 0 {
+		return segments
+	}
+
+	kept, dropped := SegmentsWithin(segments, media.Duration)
+	for _, segment := range dropped {
+		Log("Ignoring the trim %s of %s, which starts after the end of the source (%gs)", segment, file.path, media.Duration)
+	}
+	if len(kept) == 0 {
+		fatal(fmt.Sprintf("None of the trims of %s are within the source (%gs)", file.path, media.Duration))
+	}
+	return kept
+}
+
+// SegmentsWithin splits the segments into those that start before the duration and those
+// that don't, numbering the parts of the ones that are kept afresh
+func SegmentsWithin(segments []*Segment, duration float64) ([]*Segment, []*Segment) {
+	kept := []*Segment{}
+	dropped := []*Segment{}
+	for _, segment := range segments {
+		if segment.Start >= duration {
+			dropped = append(dropped, segment)
+		} else {
+			kept = append(kept, segment)
+		}
+	}
+	for index, segment := range kept {
+		segment.part = ""
+		if len(kept) > 1 {
+			segment.part = fmt.Sprintf("%d of %d", index+1, len(kept))
+		}
+	}
+	return kept, dropped
+}
+
+// Part is e.g. "2 of 3", or empty when the segment is all that is kept of its source
+func (s *Segment) Part() string {
+	return s.part
+}
+
+// so that the estimates aren't thrown by a short segment of a big file
+func segmentSize(file *FileWithSize, segment *Segment) int64 {
+	media, err := ProbeMedia(file.path)
+	if err != nil || media.Duration <= 0 {
+		return file.size
+	}
+	end := segment.End
+	if end == 0 || end > media.Duration {
+		end = media.Duration
+	}
+	if end <= segment.Start {
+		return file.size
+	}
+	return int64(float64(file.size) * (end - segment.Start) / media.Duration)
+}
+
+// -suggestTrims
+
+var blackPattern = regexp.MustCompile(`black_start:\s*([\d.]+)\s+black_end:\s*([\d.]+)`)
+var silenceStartPattern = regexp.MustCompile(`silence_start:\s*(-?[\d.]+)`)
+var silenceEndPattern = regexp.MustCompile(`silence_end:\s*([\d.]+)`)
+
+// how close to either end of the source a black or silent stretch has to be to count
+const trimTolerance = 0.5
+
+func trimDetectArgs(path string) []string {
+	return []string{
+		"-hide_banner", "-nostats",
+		"-i", path,
+		"-vf", "blackdetect=d=1:pix_th=0.10",
+		"-af", "silencedetect=n=-50dB:d=1",
+		"-f", "null",
+		"-"}
+}
+
+type stretch struct {
+	start float64
+	end   float64
+}
+
+// SuggestTrim looks at the output of blackdetect and silencedetect for a source of the given
+// length, and suggests cutting off any black at the start and end. Failing that (tape noise
+// isn't black) it falls back to any silence. Nil if there's nothing worth trimming
+func SuggestTrim(output string, duration float64) *Segment {
+	blacks := []stretch{}
+	for _, match := range blackPattern.FindAllStringSubmatch(output, -1) {
+		start, _ := strconv.ParseFloat(match[1], 64)
+		end, _ := strconv.ParseFloat(match[2], 64)
+		blacks = append(blacks, stretch{start, end})
+	}
+
+	// a silence that runs to the end of the file might never be given an end
+	silences := []stretch{}
+	for _, line := range strings.Split(output, "\n") {
+		if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+			start, _ := strconv.ParseFloat(match[1], 64)
+			silences = append(silences, stretch{start, duration})
+		} else if match := silenceEndPattern.FindStringSubmatch(line); match != nil && len(silences) > 0 {
+			silences[len(silences)-1].end, _ = strconv.ParseFloat(match[1], 64)
+		}
+	}
+
+	segment := &Segment{
+		Start: firstNonZero(leadingEnd(blacks), leadingEnd(silences)),
+		End:   firstNonZero(trailingStart(blacks, duration), trailingStart(silences, duration)),
+	}
+
+	if segment.Start == 0 && segment.End == 0 {
+		return nil
+	}
+	if segment.End != 0 && segment.End <= segment.Start {
+		return nil // it's all black, or silent, so best left to a human
+	}
+	return segment
+}
+
+func leadingEnd(stretches []stretch) float64 {
+	for _, s := range stretches {
+		if s.start <= trimTolerance {
+			return s.end
+		}
+	}
+	return 0
+}
+
+func trailingStart(stretches []stretch, duration float64) float64 {
+	for _, s := range stretches {
+		if duration > 0 && s.end >= duration-trimTolerance && s.start > trimTolerance {
+			return s.start
+		}
+	}
+	return 0
+}
+
+func firstNonZero(values ...float64) float64 {
+	for _, value := range values {
+		if value != 0 {
+			return value
+		}
+	}
+	return 0
+}
+
+func SuggestTrims(groups []*Group) {
+	files := allFilesInGroups(groups)
+
+	prefetchMediaInfo(files)
+
+	suggestions := make(map[*FileWithSize]*Segment)
+	lock := sync.Mutex{}
+
+	forEachFileInParallel(files, func(file *FileWithSize) {
+		media, err := ProbeMedia(file.path)
+		if err != nil {
+			Log("Could not probe %s: %s", file.path, err.Error())
+			return
+		}
+		_, stderr, err := probe(settings.ffmpegPath, trimDetectArgs(file.path))
+		if err != nil {
+			Log("Could not look for black or silence in %s: %s", file.path, err.Error())
+			return
+		}
+		lock.Lock()
+		suggestions[file] = SuggestTrim(stderr, media.Duration)
+		lock.Unlock()
+	})
+
+	// in sidecar form, e.g. to be pasted into 'clip.mpg.trim'
+	for _, file := range files {
+		if suggestion := suggestions[file]; suggestion != nil {
+			fmt.Printf("%s%s: %s\n", file.path, trimSidecarExtension, suggestion)
+		} else if settings.verbose {
+			Log("%s: nothing to trim", file.path)
+		}
+	}
+}
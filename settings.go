@@ -4,14 +4,32 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+const Version = "0.2.0"
+
+// for flags that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type Settings struct {
 	paths                []string
 	dryRun               bool
 	verbose              bool
 	outputRoot           string
 	logToFile            string
+	logMode              string
+	logMaxSize           int
+	logKeep              int
 	consoleOutputAllowed bool
 	liveDisplay          bool
 	singleThread         bool
@@ -21,34 +39,250 @@ type Settings struct {
 	fps                  string
 	quality              int
 	fixAudio             bool
+	autoFixAudio         bool
+	fixAudioMode         string
 	decomb               bool
+	deinterlace          string
+	deinterlacer         string
+	autoCrop             string
+	filters              string
+	filterChain          FilterChain
+	transcoder           string
+	subtitles            string
+	subtitleFormat       string
+	trims                stringList
+	suggestTrims         bool
+	doctor               bool
+	checkTools           bool
+	ffmpegPath           string
+	ffprobePath          string
+	handbrakePath        string
 	reportSizes          bool
+	profile              string
+	manifest             string
+	order                string
+	chapters             bool
+	writeTags            bool
+	tags                 stringList
+	preserveSourceTags   bool
+	provenance           bool
+	useCatalogue         bool
+	cataloguePath        string
+	force                bool
+	onCollision          string
+	plan                 string
+	planFile             string
+	graphFile            string
+	inventory            string
+	inventoryFile        string
+	histoSort            string
+	probeWorkers         int
+	useProbeCache        bool
+	probeCachePath       string
+	include              stringList
+	exclude              stringList
+	extensions           string
+	sniff                bool
+	followSymlinks       bool
+	includeHidden        bool
+	audioTracks          string
+	audioLanguage        string
+	audioCodec           string
+	audioSampleRate      int
+	audioResync          bool
+	audioOffset          time.Duration
+	audioFit             string
+	loudnorm             bool
 }
 
-var settings = Settings{}
+// the tools have to have names, and the probes somewhere to run, even if ParseArguments
+// never runs (the tests, for instance)
+var settings = Settings{
+	ffmpegPath:    "ffmpeg",
+	ffprobePath:   "ffprobe",
+	handbrakePath: "HandBrakeCLI",
+	probeWorkers:  8,
+}
 
 func ParseArguments() {
 
 	flag.BoolVar(&settings.verbose, "verbose", false, "be verbose")
-	flag.BoolVar(&settings.dryRun, "dryRun", false, "don't affect anything")
+	flag.BoolVar(&settings.dryRun, "dryRun", false, "don't affect anything, just describe what would be done")
+
+	flag.StringVar(&settings.plan, "plan", "text", "in dry run mode, the form of the plan: 'text', 'dot' (for Graphviz) or 'mermaid'")
+
+	flag.StringVar(&settings.planFile, "planFile", "", "in dry run mode, where to write the plan.\n (default is standard output)")
+
+	flag.StringVar(&settings.graphFile, "graph", "",
+		"write the task graph to this file, and keep it up to date as tasks run.\n"+
+			"Graphviz DOT, or Mermaid if the name ends in .mmd")
 	flag.BoolVar(&settings.singleThread, "singleThread", false, "do not parallelise tasks")
 
 	flag.BoolVar(&settings.liveDisplay, "liveDisplay", true, "ncurses-based visual progress updates")
 
 	flag.IntVar(&settings.maxParallelTasks, "maxParallelTasks", 4, "maximum number of tasks to have running at any one time.\n")
 
-	flag.BoolVar(&settings.decomb, "decomb", false, "use de-interlacing")
+	flag.BoolVar(&settings.decomb, "decomb", false, "use de-interlacing (the same as -deinterlace always)")
+
+	flag.StringVar(&settings.deinterlace, "deinterlace", "never",
+		"when to deinterlace: 'auto' (only the sources that look interlaced), 'always' or 'never'")
+
+	flag.StringVar(&settings.autoCrop, "autoCrop", "handbrake",
+		"how to crop black borders: 'handbrake' leaves it to HandBrake, 'none' doesn't crop,\n"+
+			"'file' detects the borders of each source, 'group' crops a whole group the same")
+
+	flag.StringVar(&settings.filters, "filters", "",
+		"video clean-up for every transcode, a comma separated list applied in the order given,\n"+
+			"e.g. 'denoise=nlmeans:light,deblock,rotate=90'. The filters are\n"+
+			"  denoise=[hqdn3d|nlmeans][:strength]   (default hqdn3d:medium)\n"+
+			"  deblock[=strength]                    (default medium)\n"+
+			"  sharpen[=strength]                    (default medium)\n"+
+			"  rotate=auto|90|180|270|hflip|vflip\n"+
+			"  colour=bt709|bt601-525|bt601-625|bt2020\n"+
+			"  range=tv|pc\n"+
+			"  trim=[start]-[end]                    e.g. trim=4s-1m30s, for every source (see -trim)\n"+
+			"where a strength is one of: "+strings.Join(filterStrengths, ", "))
+
+	flag.StringVar(&settings.subtitles, "subtitles", "drop",
+		"what to do with subtitles and closed captions: 'keep' them in the archive, 'burn' the first\n"+
+			"into the picture, 'extract' them to a file next to the archive, or 'drop' them")
+
+	flag.StringVar(&settings.subtitleFormat, "subtitleFormat", "srt",
+		"the format of extracted subtitles, 'srt' or 'vtt'")
+
+	flag.Var(&settings.trims, "trim",
+		"glob=segments, the parts to keep of the sources matching the glob, e.g. 'tape1*=0:04-1:30,2:00-'.\n"+
+			"May be given more than once. Also see the 'trims' in manifests, and <source>.trim files")
+
+	flag.BoolVar(&settings.suggestTrims, "suggestTrims", false,
+		"look for black and silence at either end of every source, and suggest trims for them.\nDoes not do any transcoding.")
+
+	flag.StringVar(&settings.ffmpegPath, "ffmpeg", settings.ffmpegPath, "the ffmpeg to use")
+	flag.StringVar(&settings.ffprobePath, "ffprobe", settings.ffprobePath, "the ffprobe to use")
+	flag.StringVar(&settings.handbrakePath, "handbrake", settings.handbrakePath, "the HandBrakeCLI to use")
+
+	flag.BoolVar(&settings.checkTools, "checkTools", true,
+		"before starting, check that the tools, encoders and filters this run needs are all there.\n"+
+			"('varchive doctor' gives the details)")
+
+	flag.StringVar(&settings.transcoder, "transcoder", "handbrake", "what does the transcoding: 'handbrake' or 'ffmpeg'")
+
+	flag.StringVar(&settings.deinterlacer, "deinterlacer", "decomb",
+		"how to deinterlace.\nOne of: "+strings.Join(deinterlacers, ", ")+" ('bob' doubles the frame rate)\n")
 	flag.BoolVar(&settings.fixAudio, "fixAudio", false, "attempt to repair the dodgy audio found on some older files")
+	flag.BoolVar(&settings.autoFixAudio, "autoFixAudio", false,
+		"check the audio of every file, and only repair the ones that look dodgy.\n"+
+			"(-fixAudio repairs them all regardless)")
+	flag.StringVar(&settings.fixAudioMode, "fixAudioMode", "single",
+		"how to repair audio: 'single' is one ffmpeg pass (falling back to 'legacy' if that fails),\n"+
+			"'legacy' splits the source into temporary audio and video files first")
+	flag.StringVar(&settings.audioTracks, "audioTracks", "one", "which audio tracks to keep: 'one' or 'all'")
+
+	flag.StringVar(&settings.audioLanguage, "audioLanguage", "",
+		"preferred language for the audio track, e.g. 'eng'.\n (default is the source's default track, or the first)")
+
+	flag.StringVar(&settings.audioCodec, "audioCodec", "mp3",
+		"when repairing audio, the codec for the repaired audio.\nOne of: "+strings.Join(audioCodecNames, ", ")+"\n")
+
+	flag.IntVar(&settings.audioSampleRate, "audioSampleRate", 0,
+		"when repairing audio, resample the audio to this rate, e.g. 48000.\n (default is to leave the rate alone)")
+
+	flag.BoolVar(&settings.audioResync, "audioResync", false,
+		"when repairing audio, stretch or squeeze the audio to correct drift against the video")
+
+	flag.DurationVar(&settings.audioOffset, "audioOffset", 0,
+		"when repairing audio, shift the audio by this much, e.g. 120ms (later) or -80ms (earlier)")
+
+	flag.StringVar(&settings.audioFit, "audioFit", "shortest",
+		"when repairing audio, when audio and video differ in length: 'shortest' cuts both to the shorter,\n"+
+			"'pad' pads the audio with silence to the length of the video")
+
+	flag.BoolVar(&settings.loudnorm, "loudnorm", false,
+		"when repairing audio, normalise the loudness of the audio (EBU R128, two passes)")
+
 	flag.IntVar(&settings.quality, "quality", 20, "encode quality.\nSmaller numbers are better quality, but slower to encode\n")
 
+	flag.BoolVar(&settings.chapters, "chapters", true, "mark each source file as a chapter in the concatenated output")
+
+	flag.BoolVar(&settings.writeTags, "writeTags", true, "write descriptive tags (title, date, sources...) into each output")
+
+	flag.Var(&settings.tags, "tag",
+		"key=template, adds or replaces a descriptive tag (may be given more than once).\n"+
+			"Templates can use {{.Title}}, {{.Date}}, {{.Description}}, {{.Profile}}, {{.Encoder}},\n"+
+			"{{.Quality}}, {{.Version}} and {{range .Sources}}{{.Name}} {{.Path}} {{.Checksum}}{{end}}.\n"+
+			"An empty template (key=) removes that tag")
+
+	flag.BoolVar(&settings.preserveSourceTags, "preserveSourceTags", true,
+		"copy camera tags (creation time, location, make, model) from the sources to the output.\n"+
+			"Anything that cannot be embedded is written to <output>.tags.json")
+
+	flag.BoolVar(&settings.provenance, "provenance", true,
+		"write <output>.varchive.json, recording the sources, tasks, tools and timings behind each output")
+
+	flag.BoolVar(&settings.useCatalogue, "useCatalogue", true,
+		"remember which sources went into which output, and skip groups that have not changed since")
+
+	flag.StringVar(&settings.cataloguePath, "catalogue", "",
+		"location of the catalogue.\n (default is varchive.catalogue.json in the output root)")
+
+	flag.BoolVar(&settings.force, "force", false, "rebuild every output, even those the catalogue says are up to date")
+
+	flag.StringVar(&settings.onCollision, "onCollision", "fail",
+		"what to do when an output already exists.\nOne of: "+strings.Join(collisionPolicies, ", ")+"\n")
+
 	flag.BoolVar(&settings.reportSizes, "reportSizes", false, "scan all files and report their video geometry.\nDoes not do any transcoding.")
 
+	flag.StringVar(&settings.profile, "profile", "default",
+		"transcode profile for groups that do not name their own.\n (one of: "+profileNames()+")")
+
+	flag.StringVar(&settings.manifest, "manifest", "",
+		"a JSON or CSV file listing, per output archive, the source files that go into it.\nMay be used instead of, or as well as, paths")
+
+	flag.StringVar(&settings.order, "order", "natural",
+		"order of the files within a directory when they are concatenated.\nOne of: "+strings.Join(orderings, ", ")+"\n")
+
+	flag.StringVar(&settings.inventory, "inventory", "",
+		"like -reportSizes, but also write the details of every file as 'csv' or 'json'")
+
+	flag.StringVar(&settings.inventoryFile, "inventoryFile", "", "where to write the inventory.\n (default is standard output)")
+
+	flag.StringVar(&settings.histoSort, "histoSort", "key", "order of the -reportSizes histograms: 'key' or 'count'")
+
+	flag.IntVar(&settings.probeWorkers, "probeWorkers", settings.probeWorkers, "maximum number of ffprobes to run at any one time")
+
+	flag.BoolVar(&settings.useProbeCache, "useProbeCache", true, "remember what ffprobe said about each file between runs")
+
+	flag.StringVar(&settings.probeCachePath, "probeCache", "",
+		"location of the probe cache.\n (default is varchive/probe-cache.json in the user's cache directory)")
+
+	flag.Var(&settings.include, "include", "only use files matching this glob (may be given more than once)")
+
+	flag.Var(&settings.exclude, "exclude", "skip files matching this glob (may be given more than once)")
+
+	flag.StringVar(&settings.extensions, "extensions", defaultVideoExtensions,
+		"the file extensions worth transcoding, or 'all'.\n"+
+			"Files can also be skipped using globs in a .varchiveignore file in their directory\n")
+
+	flag.BoolVar(&settings.followSymlinks, "followSymlinks", false, "use symbolic links to files found in directories (rather than skipping them)")
+
+	flag.BoolVar(&settings.includeHidden, "includeHidden", false, "use hidden files (.whatever) found in directories (rather than skipping them)")
+
+	flag.BoolVar(&settings.sniff, "sniff", false, "use ffprobe to skip any files that contain no video")
+
 	flag.StringVar(&settings.outputRoot, "outputRoot", "out",
 		"location for output files.\nWill be created if required.\n")
 
 	flag.StringVar(&settings.logToFile, "log", "",
 		"location for log files.\n (default is nothing, i.e. log to standard output)")
 
+	flag.StringVar(&settings.logMode, "logMode", "append",
+		"what to do with an existing log file: 'append', 'truncate' or 'rotate'")
+
+	flag.IntVar(&settings.logMaxSize, "logMaxSize", 0,
+		"in 'rotate' mode, the size (in MiB) at which the log is rotated.\n (default is 0, i.e. rotate at the start of every run)")
+
+	flag.IntVar(&settings.logKeep, "logKeep", 5, "in 'rotate' mode, the number of old logs to keep")
+
 	flag.StringVar(&settings.fps, "fps", "",
 		"frames-per-second for output file.\n (default is 'do not adjust')")
 
@@ -58,12 +292,18 @@ func ParseArguments() {
 	flag.StringVar(&settings.height, "height", "",
 		"pixel height of output file.\n (default is 'do not adjust')")
 
-	flag.Parse()
+	// 'varchive doctor [options]' is the one subcommand
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		settings.doctor = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	settings.paths = flag.Args()
 
-	if len(settings.paths) == 0 {
-		fmt.Println("At least one path is required.\n\nExciting options include:")
+	if len(settings.paths) == 0 && settings.manifest == "" && !settings.doctor {
+		fmt.Println("At least one path (or a manifest) is required.\n\nExciting options include:")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -76,6 +316,123 @@ func ParseArguments() {
 		fatal("--maxParallelTasks must be 1 or more")
 	}
 
+	if _, err := lookupProfile(settings.profile); err != nil {
+		fatal(err.Error())
+	}
+
+	if !contains(orderings, settings.order) {
+		fatal("--order must be one of: " + strings.Join(orderings, ", "))
+	}
+
+	if settings.inventory != "" && settings.inventory != "csv" && settings.inventory != "json" {
+		fatal("--inventory must be 'csv' or 'json'")
+	}
+
+	if settings.histoSort != "key" && settings.histoSort != "count" {
+		fatal("--histoSort must be 'key' or 'count'")
+	}
+
+	switch settings.plan {
+	case "text", "dot", "mermaid":
+	default:
+		fatal("--plan must be 'text', 'dot' or 'mermaid'")
+	}
+
+	if !contains(collisionPolicies, settings.onCollision) {
+		fatal("--onCollision must be one of: " + strings.Join(collisionPolicies, ", "))
+	}
+
+	if err := validateTagTemplates(); err != nil {
+		fatal(err.Error())
+	}
+
+	switch settings.logMode {
+	case "append", "truncate", "rotate":
+	default:
+		fatal("--logMode must be one of 'append', 'truncate' or 'rotate'")
+	}
+
+	for _, glob := range append(settings.include, settings.exclude...) {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			fatal(fmt.Sprintf("bad glob '%s': %s", glob, err.Error()))
+		}
+	}
+
+	if settings.audioTracks != "one" && settings.audioTracks != "all" {
+		fatal("--audioTracks must be 'one' or 'all'")
+	}
+
+	if !isKnownAudioCodec(settings.audioCodec) {
+		fatal("--audioCodec must be one of: " + strings.Join(audioCodecNames, ", "))
+	}
+
+	if settings.decomb && settings.deinterlace == "never" {
+		settings.deinterlace = "always"
+	}
+
+	if !contains(deinterlaceModes, settings.deinterlace) {
+		fatal("--deinterlace must be one of: " + strings.Join(deinterlaceModes, ", "))
+	}
+
+	if !contains(deinterlacers, settings.deinterlacer) {
+		fatal("--deinterlacer must be one of: " + strings.Join(deinterlacers, ", "))
+	}
+
+	var err error
+	if settings.filterChain, err = ParseFilterChain(settings.filters); err != nil {
+		fatal("--filters: " + err.Error())
+	}
+
+	for _, trim := range settings.trims {
+		index := strings.LastIndex(trim, "=")
+		if index < 1 {
+			fatal(fmt.Sprintf("--trim '%s' should be glob=segments", trim))
+		}
+		if _, err := filepath.Match(trim[:index], ""); err != nil {
+			fatal(fmt.Sprintf("--trim '%s': bad glob: %s", trim, err.Error()))
+		}
+		if _, err := ParseSegments(trim[index+1:]); err != nil {
+			fatal(fmt.Sprintf("--trim '%s': %s", trim, err.Error()))
+		}
+	}
+
+	if settings.transcoder != "handbrake" && settings.transcoder != "ffmpeg" {
+		fatal("--transcoder must be 'handbrake' or 'ffmpeg'")
+	}
+
+	if !contains(autoCropModes, settings.autoCrop) {
+		fatal("--autoCrop must be one of: " + strings.Join(autoCropModes, ", "))
+	}
+
+	if !contains(subtitleModes, settings.subtitles) {
+		fatal("--subtitles must be one of: " + strings.Join(subtitleModes, ", "))
+	}
+
+	if !contains(subtitleFormats, settings.subtitleFormat) {
+		fatal("--subtitleFormat must be one of: " + strings.Join(subtitleFormats, ", "))
+	}
+
+	if settings.fixAudioMode != "single" && settings.fixAudioMode != "legacy" {
+		fatal("--fixAudioMode must be 'single' or 'legacy'")
+	}
+
+	if settings.audioFit != "shortest" && settings.audioFit != "pad" {
+		fatal("--audioFit must be 'shortest' or 'pad'")
+	}
+
+	if settings.audioSampleRate < 0 {
+		fatal("--audioSampleRate cannot be negative")
+	}
+
+	if settings.probeWorkers < 1 {
+		fatal("--probeWorkers must be 1 or more")
+	}
+
+	if settings.logMaxSize < 0 || settings.logKeep < 0 {
+		fatal("--logMaxSize and --logKeep cannot be negative")
+	}
+
 	// special override when we know the ncurses based output is not active
-	settings.consoleOutputAllowed = settings.reportSizes || !settings.liveDisplay
+	settings.consoleOutputAllowed = settings.reportSizes || settings.inventory != "" || settings.suggestTrims || settings.doctor ||
+		settings.dryRun || !settings.liveDisplay
 }
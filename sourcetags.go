@@ -0,0 +1,135 @@
+package varchive
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// the camera/phone tags worth keeping from the source files. HandBrake drops all of them,
+// and the transcoded parts are only ever intermediate files, so they go straight onto
+// the final archive (where the first source to mention a tag wins, unless there is
+// a descriptive tag with the same name, see tags.go).
+//
+// Anything that could not be embedded (a different value in a later source, or the
+// rotation, which HandBrake has already applied to the pixels) goes in a sidecar:
+//
+//    <output>.tags.json
+
+var preservedTagKeys = []string{
+	"creation_time",
+	"location",
+	"location-eng",
+	"make",
+	"model",
+	"com.apple.quicktime.creationdate",
+	"com.apple.quicktime.location.iso6709",
+	"com.apple.quicktime.make",
+	"com.apple.quicktime.model",
+	"com.apple.quicktime.software",
+}
+
+type SourceTags struct {
+	Path        string            `json:"path"`
+	Tags        map[string]string `json:"tags"`
+	Rotation    float64           `json:"rotation,omitempty"`
+	NotEmbedded map[string]string `json:"notEmbedded,omitempty"`
+}
+
+type sourceTagsSidecar struct {
+	Output  string        `json:"output"`
+	Sources []*SourceTags `json:"sources"`
+}
+
+func readSourceTags(path string) (*SourceTags, error) {
+	info, err := ProbeMedia(path)
+	if err != nil {
+		return nil, err
+	}
+	return SourceTagsFrom(path, info), nil
+}
+
+// SourceTagsFrom picks the tags worth keeping out of what ffprobe said about the source
+func SourceTagsFrom(path string, info MediaInfo) *SourceTags {
+	tags := &SourceTags{Path: path, Tags: make(map[string]string), NotEmbedded: make(map[string]string)}
+	for _, key := range preservedTagKeys {
+		if value := info.FindTag(key); value != "" {
+			tags.Tags[key] = value
+		}
+	}
+	if video := info.VideoStream(); video != nil {
+		tags.Rotation = video.Rotation
+	}
+	return tags
+}
+
+// adds the source tags to the metadata for the final archive, and returns
+// everything that was read so the leftovers can be written to a sidecar
+func addSourceTagsForGroup(metadata *FfMetadata, group *Group) []*SourceTags {
+	all := []*SourceTags{}
+
+	for _, file := range group.files {
+		tags, err := readSourceTags(file.path)
+		if err != nil {
+			Log("Could not read the tags from %s: %s", file.path, err.Error())
+			continue
+		}
+		all = append(all, tags)
+	}
+
+	EmbedSourceTags(metadata, all)
+	return all
+}
+
+// EmbedSourceTags adds the tags of each source, in order, to the metadata. Whatever can't
+// be embedded is noted in the source's NotEmbedded
+func EmbedSourceTags(metadata *FfMetadata, all []*SourceTags) {
+	embedded := make(map[string]string)
+
+	for _, tags := range all {
+		keys := []string{}
+		for key := range tags.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := tags.Tags[key]
+			if existing, found := embedded[key]; !found && metadata.HasTag(key) {
+				tags.NotEmbedded[key] = value // a -tag from the command line takes precedence
+			} else if !found {
+				embedded[key] = value
+				metadata.AddTag(key, value)
+			} else if existing != value {
+				tags.NotEmbedded[key] = value
+			}
+		}
+		if tags.Rotation != 0 {
+			tags.NotEmbedded["rotation"] = strconv.FormatFloat(tags.Rotation, 'f', -1, 64) + " (applied during transcode)"
+		}
+	}
+}
+
+func NeedsSourceTagsSidecar(all []*SourceTags) bool {
+	for _, tags := range all {
+		if len(tags.NotEmbedded) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeSourceTagsSidecar(output string, all []*SourceTags) {
+	path := output + ".tags.json"
+
+	content, err := json.MarshalIndent(sourceTagsSidecar{output, all}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, content, 0644)
+	}
+	if err != nil {
+		Log("Could not write %s: %s", path, err.Error())
+	} else if settings.verbose {
+		Log("Wrote source tags to %s", path)
+	}
+}
@@ -0,0 +1,97 @@
+package varchive
+
+import (
+	"errors"
+	"fmt"
+)
+
+// which of the streams in a source FixAudio should use, by absolute stream index.
+// Rather than assuming the video is stream 0 and the audio stream 1, we ask ffprobe.
+//
+// With settings.audioTracks == "all" every audio stream is kept, otherwise just one:
+// the first in settings.audioLanguage (if given), or else the default one, or else the first
+
+type StreamSelection struct {
+	Video int
+	Audio []int
+}
+
+func SelectStreams(media MediaInfo, language string, all bool) (*StreamSelection, error) {
+	video := media.VideoStream()
+	if video == nil {
+		return nil, errors.New("no video stream")
+	}
+
+	selection := &StreamSelection{Video: video.Index, Audio: []int{}}
+
+	audio := media.StreamsOfType("audio")
+	if len(audio) == 0 {
+		return selection, nil
+	}
+
+	if all {
+		for _, stream := range audio {
+			selection.Audio = append(selection.Audio, stream.Index)
+		}
+		return selection, nil
+	}
+
+	chosen := audio[0]
+	if language != "" {
+		for _, stream := range audio {
+			if stream.Language == language {
+				selection.Audio = append(selection.Audio, stream.Index)
+				return selection, nil
+			}
+		}
+	}
+	for _, stream := range audio {
+		if stream.Default {
+			chosen = stream
+			break
+		}
+	}
+	selection.Audio = append(selection.Audio, chosen.Index)
+	return selection, nil
+}
+
+// nil means "we couldn't tell", in which case the first video and audio streams get used
+func selectStreamsFor(file *FileWithSize) *StreamSelection {
+	media, err := ProbeMedia(file.path)
+	if err != nil {
+		Log("Could not probe %s, will assume the usual streams: %s", file.path, err.Error())
+		return nil
+	}
+	selection, err := SelectStreams(media, settings.audioLanguage, settings.audioTracks == "all")
+	if err != nil {
+		Log("%s: %s, will assume the usual streams", file.path, err.Error())
+		return nil
+	}
+	return selection
+}
+
+func (s *StreamSelection) videoMap(input int) string {
+	if s == nil {
+		return fmt.Sprintf("%d:v:0", input)
+	}
+	return fmt.Sprintf("%d:%d", input, s.Video)
+}
+
+// -map arguments for the selected audio stream(s)
+func (s *StreamSelection) audioMaps(input int) []string {
+	if s == nil {
+		return []string{"-map", fmt.Sprintf("%d:a:0", input)}
+	}
+	maps := []string{}
+	for _, index := range s.Audio {
+		maps = append(maps, "-map", fmt.Sprintf("%d:%d", input, index))
+	}
+	return maps
+}
+
+func (s *StreamSelection) audioCount() int {
+	if s == nil {
+		return 1
+	}
+	return len(s.Audio)
+}
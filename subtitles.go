@@ -0,0 +1,402 @@
+package varchive
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// -subtitles says what becomes of the subtitles (and closed captions) of the sources:
+//
+//   drop     none of them make it into the archive
+//   keep     every subtitle stream is carried through to the archive (the concatenation
+//            moves the timings of each part along, as it does for the audio and video)
+//   burn     the first subtitle stream is burnt into the picture
+//   extract  the subtitles are written next to the archive as .srt or .vtt (-subtitleFormat),
+//            part by part, each part's timings moved along by the length of the parts before it
+//
+// Extraction uses the first text subtitle stream of a source, or failing that the EIA-608
+// closed captions carried in the video of a lot of TV captures. Bitmap (DVD) subtitles can't
+// be turned into text, so a source with only those adds nothing to the sidecar
+
+var subtitleModes = []string{"drop", "keep", "burn", "extract"}
+
+var subtitleFormats = []string{"srt", "vtt"}
+
+// the subtitle codecs that are pictures rather than text
+var bitmapSubtitleCodecs = []string{"dvd_subtitle", "dvb_subtitle", "hdmv_pgs_subtitle", "xsub"}
+
+func isBitmapSubtitle(codec string) bool {
+	return contains(bitmapSubtitleCodecs, codec)
+}
+
+// whether FixAudio has to pass the subtitle streams on to the Transcode
+func carryingSubtitles() bool {
+	return settings.subtitles == "keep" || settings.subtitles == "burn"
+}
+
+// one subtitle, times in seconds
+type Cue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+var srtTimingPattern = regexp.MustCompile(`(\d+):(\d+):(\d+)[,.](\d+)\s*-->\s*(\d+):(\d+):(\d+)[,.](\d+)`)
+
+var srtBlockSeparator = regexp.MustCompile(`\n\s*\n`)
+
+// ParseSrt reads SubRip, as ffmpeg writes it. Blocks without a timing line are skipped
+func ParseSrt(text string) []*Cue {
+	cues := []*Cue{}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for _, block := range srtBlockSeparator.Split(text, -1) {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		for index, line := range lines {
+			match := srtTimingPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			cues = append(cues, &Cue{
+				Start: srtTime(match[1:5]),
+				End:   srtTime(match[5:9]),
+				Text:  strings.Join(lines[index+1:], "\n")})
+			break
+		}
+	}
+
+	return cues
+}
+
+// hours, minutes, seconds and the digits after the decimal point
+func srtTime(parts []string) float64 {
+	hours, _ := strconv.ParseFloat(parts[0], 64)
+	minutes, _ := strconv.ParseFloat(parts[1], 64)
+	seconds, _ := strconv.ParseFloat(parts[2]+"."+parts[3], 64)
+	return hours*3600 + minutes*60 + seconds
+}
+
+// ClipCues keeps the cues (or the parts of them) that fall inside the segment, timed from
+// its start. A nil segment is the whole of the source
+func ClipCues(cues []*Cue, segment *Segment) []*Cue {
+	if segment == nil {
+		return cues
+	}
+
+	clipped := []*Cue{}
+	for _, cue := range cues {
+		start, end := math.Max(cue.Start, segment.Start), cue.End
+		if segment.End > 0 && end > segment.End {
+			end = segment.End
+		}
+		if end <= start {
+			continue
+		}
+		clipped = append(clipped, &Cue{start - segment.Start, end - segment.Start, cue.Text})
+	}
+	return clipped
+}
+
+// MergeSubtitles puts the cues of each part one after another, each part's moved along by
+// the durations of the parts before it. A cue that runs on past the end of its part is cut
+// short, rather than hang over into the next one
+func MergeSubtitles(parts [][]*Cue, durations []float64) []*Cue {
+	merged := []*Cue{}
+
+	offset := 0.0
+	for index, part := range parts {
+		for _, cue := range part {
+			end := cue.End
+			if durations[index] > 0 && end > durations[index] {
+				end = durations[index]
+			}
+			if end <= cue.Start {
+				continue
+			}
+			merged = append(merged, &Cue{cue.Start + offset, end + offset, cue.Text})
+		}
+		offset += durations[index]
+	}
+
+	return merged
+}
+
+func FormatSrt(cues []*Cue) string {
+	var b strings.Builder
+	for index, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", index+1, cueTime(cue.Start, ","), cueTime(cue.End, ","), cue.Text)
+	}
+	return b.String()
+}
+
+func FormatVtt(cues []*Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", cueTime(cue.Start, "."), cueTime(cue.End, "."), cue.Text)
+	}
+	return b.String()
+}
+
+// hh:mm:ss,mmm for SubRip, hh:mm:ss.mmm for WebVTT
+func cueTime(seconds float64, separator string) string {
+	milliseconds := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, separator, milliseconds%1000)
+}
+
+// a value for a filter option, inside a filtergraph: escaped once for the option, and again
+// for the graph
+func escapeFilterValue(value string) string {
+	for _, special := range []string{`\`, `'`, `:`} {
+		value = strings.ReplaceAll(value, special, `\`+special)
+	}
+	escaped := ""
+	for _, r := range value {
+		if strings.ContainsRune(`\'[],;`, r) {
+			escaped += `\`
+		}
+		escaped += string(r)
+	}
+	return escaped
+}
+
+// the ffmpeg command that writes the subtitles of the source to output as SubRip
+func subtitleExtractArgs(path string, media MediaInfo, output string) ([]string, error) {
+	for _, stream := range media.StreamsOfType("subtitle") {
+		if !isBitmapSubtitle(stream.CodecName) {
+			return []string{
+				"-hide_banner",
+				"-i", path,
+				"-map", fmt.Sprintf("0:%d", stream.Index),
+				"-f", "srt",
+				"-y", output}, nil
+		}
+	}
+
+	// the captions come out of the video decoder, so the whole video has to be decoded
+	if video := media.VideoStream(); video != nil && video.Captions {
+		return []string{
+			"-hide_banner",
+			"-f", "lavfi",
+			"-i", "movie=" + escapeFilterValue(path) + "[out0+subcc]",
+			"-map", "0:s",
+			"-f", "srt",
+			"-y", output}, nil
+	}
+
+	if len(media.StreamsOfType("subtitle")) > 0 {
+		return nil, fmt.Errorf("only has bitmap subtitles, which can't be turned into text")
+	}
+	return nil, fmt.Errorf("has no subtitles or closed captions")
+}
+
+// the whole of the source's subtitles, or nil if there are none to be had
+func extractSubtitles(task *Task, source *FileWithSize) []*Cue {
+	media, err := ProbeMedia(source.path)
+	if err != nil {
+		Log("Could not probe %s for subtitles: %s", source.path, err.Error())
+		return nil
+	}
+
+	output := makeTemporaryFile(".srt")
+	defer removeTemporaryFile(output)
+
+	args, err := subtitleExtractArgs(source.path, media, output)
+	if err != nil {
+		if settings.verbose {
+			Log("No subtitles from %s, it %s", source.path, err.Error())
+		}
+		return nil
+	}
+
+	if _, err := task.tryInvoke(settings.ffmpegPath, args); err != nil {
+		Log("Could not extract the subtitles of %s: %s", source.path, err.Error())
+		return nil
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		Log("Could not read the subtitles of %s: %s", source.path, err.Error())
+		return nil
+	}
+	return ParseSrt(string(content))
+}
+
+// the subtitles for the whole of the archive, the parts timed by the transcoded parts
+// (which must still be there)
+func subtitlesForParts(task *Task) ([]*Cue, error) {
+	bySource := make(map[string][]*Cue)
+	parts := [][]*Cue{}
+	durations := []float64{}
+
+	for _, dependee := range task.dependsOn {
+		info, err := probeMediaUncached(dependee.fileOut)
+		if err != nil {
+			return nil, err
+		}
+		if info.Duration <= 0 {
+			return nil, fmt.Errorf("could not find the duration of %s", dependee.fileOut)
+		}
+		durations = append(durations, info.Duration)
+
+		cues := []*Cue{}
+		if dependee.source != nil {
+			path := dependee.source.path
+			if _, found := bySource[path]; !found {
+				bySource[path] = extractSubtitles(task, dependee.source)
+			}
+			cues = ClipCues(bySource[path], trimFor(dependee))
+		}
+		parts = append(parts, cues)
+	}
+
+	return MergeSubtitles(parts, durations), nil
+}
+
+// movie.mp4 -> movie.srt, which is where players look
+func subtitlesSidecarPath(output string) string {
+	return strings.TrimSuffix(output, getFileExtension(output)) + "." + settings.subtitleFormat
+}
+
+func writeSubtitlesSidecar(task *Task) {
+	cues, err := subtitlesForParts(task)
+	if err != nil {
+		Log("No subtitles for %s: %s", task.fileOut, err.Error())
+		return
+	}
+	if len(cues) == 0 {
+		if settings.verbose {
+			Log("None of the sources of %s have subtitles to extract", task.fileOut)
+		}
+		return
+	}
+
+	content := FormatSrt(cues)
+	if settings.subtitleFormat == "vtt" {
+		content = FormatVtt(cues)
+	}
+
+	path := subtitlesSidecarPath(task.fileOut)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		Log("Could not write %s: %s", path, err.Error())
+	} else if settings.verbose {
+		Log("Wrote subtitles to %s", path)
+	}
+}
+
+// the first subtitle stream of the source, if it is to be burnt in
+func burnInFor(task *Task) *StreamInfo {
+	if settings.subtitles != "burn" || task.source == nil {
+		return nil
+	}
+	media, err := ProbeMedia(task.source.path)
+	if err != nil {
+		return nil
+	}
+	if streams := media.StreamsOfType("subtitle"); len(streams) > 0 {
+		return streams[0]
+	}
+	return nil
+}
+
+// the subtitles filter reads the file for itself, from the beginning, so a trimmed
+// transcode has its picture put back on the source's clock while the subtitles go on
+func textSubtitleFilter(task *Task) string {
+	filter := "subtitles=" + escapeFilterValue(task.fileIn) + ":si=0"
+	if trim := trimFor(task); trim != nil && trim.Start > 0 {
+		return fmt.Sprintf("setpts=PTS+%g/TB,%s,setpts=PTS-STARTPTS", trim.Start, filter)
+	}
+	return filter
+}
+
+// -map and -c:s for carrying the subtitles of the source through FixAudio into a Matroska
+// file, which can't take mov_text: text goes in as SubRip, and bitmaps are copied
+func fixAudioSubtitleArgs(task *Task, input int) []string {
+	media, err := ProbeMedia(task.fileIn)
+	if err != nil {
+		return []string{"-map", fmt.Sprintf("%d:s?", input), "-scodec", "copy"}
+	}
+
+	args := []string{}
+	for number, stream := range media.StreamsOfType("subtitle") {
+		codec := "srt"
+		if isBitmapSubtitle(stream.CodecName) {
+			codec = "copy"
+		}
+		args = append(args,
+			"-map", fmt.Sprintf("%d:%d", input, stream.Index),
+			fmt.Sprintf("-c:s:%d", number), codec)
+	}
+	return args
+}
+
+// the subtitle codecs an mp4 can carry as they are
+var mp4BitmapSubtitleCodecs = []string{"dvd_subtitle"}
+
+// -map and -c:s for keeping the subtitles in an mp4, stream by stream: text goes in as
+// mov_text, DVD bitmaps are copied, and the other bitmaps (which an mp4 can't take) are left
+// out. The streams are picked by their place among the subtitles, which a FixAudio in front
+// of the transcode keeps
+func ffmpegSubtitleArgs(task *Task) []string {
+	if task.source == nil {
+		return []string{"-map", "0:s?", "-c:s", "mov_text"}
+	}
+	media, err := ProbeMedia(task.source.path)
+	if err != nil {
+		return []string{"-map", "0:s?", "-c:s", "mov_text"}
+	}
+
+	args := []string{}
+	kept := 0
+	for number, stream := range media.StreamsOfType("subtitle") {
+		codec := "mov_text"
+		if isBitmapSubtitle(stream.CodecName) {
+			if !contains(mp4BitmapSubtitleCodecs, stream.CodecName) {
+				Log("Leaving out subtitle stream %d (%s) of %s, an mp4 can't carry it", stream.Index, stream.CodecName, task.source.path)
+				continue
+			}
+			codec = "copy"
+		}
+		args = append(args,
+			"-map", fmt.Sprintf("0:s:%d", number),
+			fmt.Sprintf("-c:s:%d", kept), codec)
+		kept++
+	}
+	return args
+}
+
+func handBrakeSubtitleArgs() []string {
+	switch settings.subtitles {
+	case "keep":
+		return []string{"--all-subtitles"}
+	case "burn":
+		return []string{"--subtitle", "1", "--subtitle-burned"}
+	}
+	return []string{"--subtitle", "none"}
+}
+
+// the concatenation takes its streams from the first part, so a group whose sources don't
+// all have subtitles might lose some
+func checkSubtitlesAgree(group *Group) {
+	with, without := 0, 0
+	for _, file := range group.files {
+		media, err := ProbeMedia(file.path)
+		if err != nil {
+			continue
+		}
+		if len(media.StreamsOfType("subtitle")) > 0 {
+			with++
+		} else {
+			without++
+		}
+	}
+	if with > 0 && without > 0 {
+		Log("Only %d of the %d sources of %s have subtitles, so some may be lost (-subtitles extract would keep them)",
+			with, with+without, group.OutputPath())
+	}
+}
@@ -0,0 +1,187 @@
+package varchive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// descriptive tags written into every concatenated output. Each one is a text/template
+// expanded against a TagData, and anything that expands to nothing is left out.
+// They can be replaced (or added to) with -tag key=template, and -tag key= drops one
+
+var defaultTagTemplates = [][2]string{
+	{"title", "{{.Title}}"},
+	{"date", "{{.Date}}"},
+	{"description", "{{.Description}}"},
+	{"comment", "Archived by varchive {{.Version}} using profile '{{.Profile}}'"},
+	{"encoder_profile", "{{.Profile}} ({{.Encoder}}, quality {{.Quality}})"},
+	{"varchive_version", "{{.Version}}"},
+	{"varchive_sources", "{{range .Sources}}{{.Name}} sha256:{{.Checksum}}\n{{end}}"},
+}
+
+type TagData struct {
+	Title       string
+	Date        string // YYYY-MM-DD, from the manifest or when the earliest source was recorded
+	Description string
+	Profile     string
+	Encoder     string
+	Quality     int
+	Version     string
+
+	group   *Group
+	sources []TagSource
+}
+
+type TagSource struct {
+	Name     string
+	Path     string
+	Checksum string
+}
+
+func newTagData(group *Group) *TagData {
+	return &TagData{
+		Title:       group.title,
+		Date:        groupDate(group),
+		Description: group.description,
+		Profile:     group.profile.name,
+		Encoder:     group.profile.encoder,
+		Quality:     group.profile.quality,
+		Version:     Version,
+		group:       group,
+	}
+}
+
+// checksumming is slow, so only do it if a template asks for it
+func (d *TagData) Sources() []TagSource {
+	if d.sources == nil {
+		d.sources = []TagSource{}
+		for _, file := range d.group.files {
+			checksum, err := sourceChecksum(file)
+			if err != nil {
+				Log("Could not checksum %s: %s", file.path, err.Error())
+				checksum = "unknown"
+			}
+			d.sources = append(d.sources, TagSource{lastBitOfPath(file.path), file.path, checksum})
+		}
+	}
+	return d.sources
+}
+
+func groupDate(group *Group) string {
+	if group.date != "" {
+		return group.date
+	}
+	if len(group.files) == 0 {
+		return ""
+	}
+	var earliest time.Time
+	for index, file := range group.files {
+		date := file.modTime
+		if info, err := ProbeMedia(file.path); err == nil {
+			date = SourceDate(info, file.modTime)
+		}
+		if index == 0 || date.Before(earliest) {
+			earliest = date
+		}
+	}
+	return earliest.Format("2006-01-02")
+}
+
+// SourceDate is when the source was recorded: the container's creation_time if it has one
+// (the camera's clock), otherwise the file's modification time, which copying can change
+func SourceDate(info MediaInfo, modTime time.Time) time.Time {
+	creation := info.FindTag("creation_time")
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if date, err := time.Parse(layout, creation); err == nil {
+			return date
+		}
+	}
+	return modTime
+}
+
+// the default templates, overlaid with anything from the command line, in a predictable order
+func tagTemplates() ([][2]string, error) {
+	templates := make(map[string]string)
+	order := []string{}
+
+	add := func(key string, text string) {
+		if _, found := templates[key]; !found {
+			order = append(order, key)
+		}
+		templates[key] = text
+	}
+
+	for _, tag := range defaultTagTemplates {
+		add(tag[0], tag[1])
+	}
+
+	extras := []string{}
+	for _, tag := range settings.tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("--tag needs to look like key=template, not '%s'", tag)
+		}
+		key := strings.TrimSpace(parts[0])
+		if _, found := templates[key]; !found {
+			extras = append(extras, key)
+		}
+		templates[key] = parts[1]
+	}
+	sort.Strings(extras)
+
+	result := [][2]string{}
+	for _, key := range append(order, extras...) {
+		if templates[key] != "" {
+			result = append(result, [2]string{key, templates[key]})
+		}
+	}
+	return result, nil
+}
+
+func validateTagTemplates() error {
+	templates, err := tagTemplates()
+	if err != nil {
+		return err
+	}
+	for _, tag := range templates {
+		if _, err := template.New(tag[0]).Parse(tag[1]); err != nil {
+			return fmt.Errorf("--tag %s: %s", tag[0], err.Error())
+		}
+	}
+	return nil
+}
+
+func addTagsForGroup(metadata *FfMetadata, group *Group) error {
+	templates, err := tagTemplates()
+	if err != nil {
+		return err
+	}
+
+	data := newTagData(group)
+
+	for _, tag := range templates {
+		value, err := ExpandTagTemplate(tag[1], data)
+		if err != nil {
+			return fmt.Errorf("tag %s: %s", tag[0], err.Error())
+		}
+		if value != "" {
+			metadata.AddTag(tag[0], value)
+		}
+	}
+	return nil
+}
+
+func ExpandTagTemplate(text string, data *TagData) (string, error) {
+	t, err := template.New("tag").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
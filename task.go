@@ -25,7 +25,8 @@ const (
 type Task struct {
 	id int
 
-	inputSize int64
+	inputSize  int64
+	outputSize int64 // only known once the task is complete
 
 	startTimestamp   Timestamp
 	runTimeInSeconds float64
@@ -38,6 +39,16 @@ type Task struct {
 	fileOut string
 
 	dependsOn []*Task
+
+	profile *Profile
+	group   *Group
+	source  *FileWithSize // the original file, for FixAudio and Transcode tasks
+
+	commands [][]string // everything that was invoked, for the record
+
+	streams *StreamSelection // for FixAudio, nil if unknown
+
+	segment *Segment // for Transcode, nil for the whole of the source
 }
 
 func (t *Task) addDependant(other *Task) {
@@ -51,11 +62,26 @@ func (t *Task) addDependants(others []*Task) {
 var taskId = 1
 
 func NewTask(taskType TaskType, fileIn string, fileOut string, inputSize int64) *Task {
-	task := Task{taskId, inputSize, time.Time{}, 0, 0, Pending, taskType, fileIn, fileOut, []*Task{}}
+	task := Task{taskId, inputSize, 0, time.Time{}, 0, 0, Pending, taskType, fileIn, fileOut, []*Task{}, nil, nil, nil, [][]string{}, nil, nil}
 	taskId += 1
 	return &task
 }
 
+// invokes the command, and remembers that we did
+func (t *Task) invoke(command string, args []string) string {
+	t.commands = append(t.commands, append([]string{command}, args...))
+	return invoke(command, args)
+}
+
+func (t *Task) tryInvoke(command string, args []string) (string, error) {
+	t.commands = append(t.commands, append([]string{command}, args...))
+	return tryInvoke(command, args)
+}
+
+func (t *Task) Id() int {
+	return t.id
+}
+
 func (t *Task) EstimatedRemainingTimeInSeconds() float64 {
 	return t.estimatedRemainingTimeInSeconds
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_diagnoseAudio(t *testing.T) {
+	tests := []struct {
+		probe    string
+		scan     string
+		expected string
+	}{
+		// nothing wrong
+		{`{"streams": [{"index": 0, "codec_type": "video", "duration": "60.0"},
+		               {"index": 1, "codec_type": "audio", "codec_name": "mp2", "duration": "60.2"}]}`,
+			"", ""},
+		// no audio, so nothing to fix
+		{`{"streams": [{"index": 0, "codec_type": "video", "duration": "60.0"}]}`,
+			"", ""},
+		// odd PCM, and the audio stops early
+		{`{"streams": [{"index": 0, "codec_type": "video", "duration": "60.0"},
+		               {"index": 1, "codec_type": "audio", "codec_name": "pcm_dvd", "duration": "55.0"}]}`,
+			"", "unsupported audio codec 'pcm_dvd'; audio lasts 55.00s, video 60.00s"},
+		// complaints while reading it through
+		{`{"streams": [{"index": 0, "codec_type": "video"},
+		               {"index": 1, "codec_type": "audio", "codec_name": "mp2"}]}`,
+			"[mp2 @ 0x1] Application provided invalid, non monotonically increasing dts to muxer\n" +
+				"[mpeg @ 0x2] Non-monotonous DTS in output stream 0:0\n" +
+				"[mp2 @ 0x3] Header missing\n" +
+				"Error while decoding stream #0:1: Invalid data found when processing input\n",
+			"2 timestamp discontinuities; 1 decode errors"},
+	}
+
+	for index, test := range tests {
+		media, err := varchive.ParseMediaInfo([]byte(test.probe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems := varchive.DiagnoseAudio(media, test.scan)
+		assertEqual(t, "test "+string(rune('0'+index)), test.expected, strings.Join(problems, "; "))
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_parseLoudnormOutput(t *testing.T) {
+	output := `Input #0, mpeg, from 'sample 1.mpg':
+  Duration: 00:00:12.50, start: 0.500000, bitrate: 5000 kb/s
+[Parsed_loudnorm_0 @ 0x55d5c5a0] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+
+	loudness, err := varchive.ParseLoudnormOutput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "input_i", "-27.61", loudness.InputI)
+	assertEqual(t, "input_tp", "-4.47", loudness.InputTP)
+	assertEqual(t, "input_lra", "18.06", loudness.InputLRA)
+	assertEqual(t, "input_thresh", "-39.20", loudness.InputThresh)
+	assertEqual(t, "target_offset", "0.58", loudness.TargetOffset)
+}
+
+func Test_parseLoudnormOutputWhenSilent(t *testing.T) {
+	_, err := varchive.ParseLoudnormOutput(`{"input_i" : "-inf", "target_offset" : "inf"}`)
+	if err == nil {
+		t.Fatal("expected silence to be an error")
+	}
+
+	_, err = varchive.ParseLoudnormOutput("no measurements here")
+	if err == nil {
+		t.Fatal("expected missing measurements to be an error")
+	}
+}
+
+// a stand-in for ffprobe or ffmpeg, so the test doesn't depend on what is installed
+func fakeTool(t *testing.T, directory string, name string, script string) string {
+	path := filepath.Join(directory, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_audioFallbackAfterRepair(t *testing.T) {
+	parseTestArguments()
+	setFlag(t, "useCatalogue", "false")
+
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+
+	// the DVD style PCM audio that -autoFixAudio repairs
+	tools := t.TempDir()
+	setFlag(t, "ffprobe", fakeTool(t, tools, "ffprobe", `echo '{"format": {"duration": "10"}, "streams": [`+
+		`{"index": 0, "codec_type": "video", "codec_name": "mpeg2video"}, {"index": 1, "codec_type": "audio", "codec_name": "pcm_dvd"}]}'`))
+	setFlag(t, "ffmpeg", fakeTool(t, tools, "ffmpeg", "exit 0"))
+
+	tests := []struct {
+		fixAudio     string
+		autoFixAudio string
+		audioCodec   string
+		expected     bool
+	}{
+		{"true", "false", "opus", true},
+		{"true", "false", "flac", true},
+		{"true", "false", "mp3", false}, // mp4 takes these as they are
+		{"true", "false", "aac", false},
+		{"false", "true", "opus", true}, // repaired all the same
+		{"false", "true", "mp3", false},
+		{"false", "false", "opus", false}, // nothing was repaired, so the codec doesn't come into it
+	}
+
+	for _, test := range tests {
+		setFlag(t, "fixAudio", test.fixAudio)
+		setFlag(t, "autoFixAudio", test.autoFixAudio)
+		setFlag(t, "audioCodec", test.audioCodec)
+
+		tasks := varchive.GenerateTasks([]*varchive.Group{catalogueTestGroup(t, directory, "")})
+		plan := varchive.RenderPlan(tasks, varchive.NewEstimator())
+
+		name := "fixAudio=" + test.fixAudio + " autoFixAudio=" + test.autoFixAudio + " audioCodec=" + test.audioCodec
+		assertEqual(t, name+" repairs", map[bool]int{true: 2, false: 0}[test.fixAudio == "true" || test.autoFixAudio == "true"],
+			strings.Count(plan, "FixAudio"))
+		assertEqual(t, name+" fallbacks", map[bool]int{true: 2, false: 0}[test.expected], strings.Count(plan, "--audio-fallback av_aac"))
+	}
+}
+
+func Test_fixAudioModes(t *testing.T) {
+	parseTestArguments()
+	setFlag(t, "useCatalogue", "false")
+	setFlag(t, "fixAudio", "true")
+
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+
+	tools := t.TempDir()
+	setFlag(t, "ffprobe", fakeTool(t, tools, "ffprobe", `echo '{"format": {"duration": "10"}, "streams": [`+
+		`{"index": 0, "codec_type": "video", "codec_name": "mpeg2video", "duration": "10"},`+
+		`{"index": 1, "codec_type": "audio", "codec_name": "mp2", "duration": "10"}]}'`))
+	setFlag(t, "ffmpeg", fakeTool(t, tools, "ffmpeg", "exit 0"))
+
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		single  []string // in the one command
+		legacy  []string // in one of the three
+		neither []string
+	}{
+		{
+			name:    "defaults",
+			single:  []string{"-map 0:0 -map 0:1 -vcodec copy -acodec mp3 -shortest -y"},
+			legacy:  []string{"-map 0:0 -codec copy", "-map 0:1 -codec mp3", "-map 0:v:0 -map 1:a -acodec copy -vcodec copy -shortest"},
+			neither: []string{"-af ", "-ar ", "-itsoffset"},
+		},
+		{
+			name:   "codec",
+			flags:  map[string]string{"audioCodec": "opus"},
+			single: []string{"-acodec libopus"},
+			legacy: []string{"-codec libopus"},
+		},
+		{
+			name:   "sample rate",
+			flags:  map[string]string{"audioSampleRate": "44100"},
+			single: []string{"-ar 44100 -acodec mp3"},
+			legacy: []string{"-ar 44100 -codec mp3"},
+		},
+		{
+			name:   "resync",
+			flags:  map[string]string{"audioResync": "true"},
+			single: []string{"-af aresample=async=1000:first_pts=0 -acodec"},
+			legacy: []string{"-af aresample=async=1000:first_pts=0 -codec"},
+		},
+		{
+			name:    "pad",
+			flags:   map[string]string{"audioFit": "pad"},
+			single:  []string{"-af apad=whole_dur=10.000"},
+			legacy:  []string{"-af apad=whole_dur=10.000"},
+			neither: []string{"-shortest"},
+		},
+		{
+			name:   "offset",
+			flags:  map[string]string{"audioOffset": "250ms"},
+			single: []string{"-itsoffset 0.250 -i " + filepath.Join(directory, "a.mpg"), "-map 0:0 -map 1:1"},
+			legacy: []string{"-itsoffset 0.250 -i '<audio stream>'"},
+		},
+		{
+			name:   "loudness",
+			flags:  map[string]string{"loudnorm": "true"},
+			single: []string{"-af loudnorm=I=-16:TP=-1.5:LRA=11 -ar 48000 -acodec"},
+			legacy: []string{"-af loudnorm=I=-16:TP=-1.5:LRA=11 -ar 48000 -codec"},
+		},
+	}
+
+	plan := func(t *testing.T, mode string, flags map[string]string) string {
+		t.Helper()
+		setFlag(t, "fixAudioMode", mode)
+		for name, value := range flags {
+			setFlag(t, name, value)
+		}
+		tasks := varchive.GenerateTasks([]*varchive.Group{catalogueTestGroup(t, directory, "")})
+		return varchive.RenderPlan(tasks, varchive.NewEstimator())
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			single := plan(t, "single", test.flags)
+			legacy := plan(t, "legacy", test.flags)
+
+			for _, expected := range test.single {
+				assertEqual(t, "single pass has "+expected, true, strings.Contains(single, expected))
+			}
+			for _, expected := range test.legacy {
+				assertEqual(t, "legacy has "+expected, true, strings.Contains(legacy, expected))
+			}
+			for _, unexpected := range test.neither {
+				assertEqual(t, "single pass without "+unexpected, false, strings.Contains(single, unexpected))
+				assertEqual(t, "legacy without "+unexpected, false, strings.Contains(legacy, unexpected))
+			}
+			assertEqual(t, "legacy writes and reads back the audio of each source", 4, strings.Count(legacy, "<audio stream>"))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+// a manifest with the one group, built from two sources in a fresh directory. The output
+// root is the current directory when the arguments haven't been parsed, hence the Chdir
+func catalogueTestGroup(t *testing.T, directory string, filters string) *varchive.Group {
+	manifest := filepath.Join(directory, "manifest.json")
+	json := fmt.Sprintf(`[{"output": "out.mp4", "profile": "default", "filters": "%s", "sources": ["a.mpg", "b.mpg"]}]`,
+		filters)
+	if err := os.WriteFile(manifest, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := varchive.ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groups[0]
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_catalogueCheckAndRecord(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+
+	catalogue, err := varchive.OpenCatalogue(filepath.Join(directory, "catalogue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := catalogueTestGroup(t, directory, "deblock")
+	assertEqual(t, "before recording", varchive.NotCatalogued, catalogue.Check(group))
+
+	writeTestFile(t, filepath.Join(directory, "out.mp4"), "archive")
+	if err := catalogue.Record(group); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "after recording", varchive.Unchanged, catalogue.Check(group))
+
+	// and it survives being read back in
+	reopened, err := varchive.OpenCatalogue(filepath.Join(directory, "catalogue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "reopened", varchive.Unchanged, reopened.Check(group))
+
+	// different filters make a different archive
+	assertEqual(t, "new filters", varchive.Changed, catalogue.Check(catalogueTestGroup(t, directory, "deblock,sharpen")))
+
+	// as does a missing archive
+	os.Remove(filepath.Join(directory, "out.mp4"))
+	assertEqual(t, "archive gone", varchive.Changed, catalogue.Check(group))
+}
+
+func Test_catalogueNoticesChangedSources(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+	writeTestFile(t, filepath.Join(directory, "out.mp4"), "archive")
+
+	catalogue, err := varchive.OpenCatalogue(filepath.Join(directory, "catalogue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := catalogue.Record(catalogueTestGroup(t, directory, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a different size, so the checksum is worked out again
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second, edited")
+	assertEqual(t, "edited source", varchive.Changed, catalogue.Check(catalogueTestGroup(t, directory, "")))
+}
+
+var parseArgumentsOnce sync.Once
+
+// the defaults a real run would have, with the flags registered so that tests can change
+// them with flag.Set (the output root is left as the current directory)
+func parseTestArguments() {
+	parseArgumentsOnce.Do(func() {
+		arguments := os.Args
+		os.Args = []string{"varchive", "-outputRoot=", "-liveDisplay=false", "-useProbeCache=false", "."}
+		varchive.ParseArguments()
+		os.Args = arguments
+	})
+}
+
+// changes a setting for the rest of the test
+func setFlag(t *testing.T, name string, value string) {
+	was := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, was) })
+}
+
+func Test_settingsFingerprint(t *testing.T) {
+	parseTestArguments()
+
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+	group := catalogueTestGroup(t, directory, "")
+	original := varchive.SettingsFingerprint(group)
+
+	changes := [][2]string{
+		{"transcoder", "ffmpeg"},
+		{"deinterlace", "always"},
+		{"deinterlacer", "bob"},
+		{"autoCrop", "file"},
+		{"subtitles", "keep"},
+		{"subtitleFormat", "vtt"},
+		{"audioTracks", "all"},
+		{"audioLanguage", "fra"},
+		{"fixAudio", "true"},
+		{"autoFixAudio", "true"},
+		{"fixAudioMode", "legacy"},
+		{"audioCodec", "aac"},
+		{"audioSampleRate", "44100"},
+		{"audioResync", "true"},
+		{"audioOffset", "250ms"},
+		{"audioFit", "pad"},
+		{"loudnorm", "true"},
+		{"order", "mtime"},
+		{"chapters", "false"},
+		{"writeTags", "false"},
+		{"preserveSourceTags", "false"},
+		{"trim", "a.mpg=0:04-"},
+		{"tag", "comment=Digitised by Dad"}, // can't be unset, so it goes last
+	}
+
+	for _, change := range changes {
+		name, value := change[0], change[1]
+		was := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "fingerprint after -"+name+"="+value, true, varchive.SettingsFingerprint(group) != original)
+		if name != "tag" && name != "trim" {
+			flag.Set(name, was)
+			assertEqual(t, "fingerprint after -"+name+" is put back", original, varchive.SettingsFingerprint(group))
+		}
+	}
+}
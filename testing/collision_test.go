@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_decideCollision(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	cases := []struct {
+		policy   string
+		output   time.Time
+		source   time.Time
+		expected varchive.CollisionDecision
+	}{
+		{"fail", older, newer, varchive.RefuseToStart},
+		{"skip", older, newer, varchive.SkipGroup},
+		{"overwrite", newer, older, varchive.OverwriteOutput},
+		{"rename", older, newer, varchive.RenameOutput},
+		{"overwrite-if-older", older, newer, varchive.OverwriteOutput},
+		{"overwrite-if-older", newer, older, varchive.SkipGroupAsUpToDate},
+		{"overwrite-if-older", older, older, varchive.SkipGroupAsUpToDate},
+	}
+
+	for _, c := range cases {
+		assertEqual(t, c.policy, c.expected, varchive.DecideCollision(c.policy, c.output, c.source))
+	}
+}
+
+func Test_firstFreeOutputPath(t *testing.T) {
+	directory := t.TempDir()
+	output := filepath.Join(directory, "tape.mp4")
+
+	for _, name := range []string{"tape.mp4", "tape-1.mp4"} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte("in the way"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertEqual(t, "first free", filepath.Join(directory, "tape-2.mp4"),
+		varchive.FirstFreeOutputPath(output, map[string]bool{}))
+
+	// another group has already been given tape-2.mp4
+	claimed := map[string]bool{filepath.Join(directory, "tape-2.mp4"): true}
+	assertEqual(t, "claimed", filepath.Join(directory, "tape-3.mp4"),
+		varchive.FirstFreeOutputPath(output, claimed))
+}
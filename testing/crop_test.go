@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_parseCropDetect(t *testing.T) {
+	// letterboxed 720x576, one frame with a caption dipping into the bottom border,
+	// and one frame entirely black (which cropdetect reports inside out)
+	output := `[Parsed_cropdetect_0 @ 0x55] x1:0 x2:719 y1:72 y2:503 w:720 h:432 x:0 y:72 pts:0 t:0.000000 crop=720:432:0:72
+[Parsed_cropdetect_0 @ 0x55] x1:2 x2:717 y1:73 y2:502 w:716 h:430 x:2 y:72 pts:3600 t:0.040000 crop=716:430:2:72
+[Parsed_cropdetect_0 @ 0x55] x1:0 x2:719 y1:74 y2:521 w:720 h:448 x:0 y:74 pts:7200 t:0.080000 crop=720:448:0:74
+[Parsed_cropdetect_0 @ 0x55] x1:719 x2:0 y1:575 y2:0 w:-704 h:-560 x:8 y:8 pts:10800 t:0.120000 crop=-704:-560:8:8
+`
+
+	crop, err := varchive.ParseCropDetect(output, 720, 576)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "crop", "72:54:0:0", crop.String())
+}
+
+func Test_parseCropDetectWithNoPicture(t *testing.T) {
+	_, err := varchive.ParseCropDetect("x1:719 x2:0 y1:575 y2:0 w:-704 h:-560", 720, 576)
+	if err == nil {
+		t.Fatal("expected an all black video to be an error")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_decideInterlacing(t *testing.T) {
+	tests := []struct {
+		fieldOrder string
+		idet       string
+		expected   string
+	}{
+		// idet wins over the field order, in both directions
+		{"progressive", "[Parsed_idet_0 @ 0x5581] Multi frame detection: TFF:   394 BFF:     0 Progressive:     3 Undetermined:   103\n",
+			"yes (idet: 394 TFF, 0 BFF, 3 progressive)"},
+		{"tt", "[Parsed_idet_0 @ 0x5581] Multi frame detection: TFF:     2 BFF:     1 Progressive:   480 Undetermined:    17\n",
+			"no (idet: 2 TFF, 1 BFF, 480 progressive)"},
+		// no idet, so go with the field order
+		{"bb", "", "yes (field order bb)"},
+		{"progressive", "", "no (field order progressive)"},
+		// idet saw nothing it could decide on
+		{"", "Multi frame detection: TFF: 0 BFF: 0 Progressive: 0 Undetermined: 500", "no (could not tell)"},
+	}
+
+	for _, test := range tests {
+		actual := varchive.DecideInterlacing(test.fieldOrder, test.idet).String()
+		assertEqual(t, "field order "+test.fieldOrder, test.expected, actual)
+	}
+}
+
+func Test_deinterlacers(t *testing.T) {
+	parseTestArguments()
+	setFlag(t, "useCatalogue", "false")
+	setFlag(t, "deinterlace", "always")
+
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+
+	tests := []struct {
+		deinterlacer string
+		handBrake    string
+		ffmpeg       string
+	}{
+		// only bob gives a frame per field, the rest keep the frame rate
+		{"decomb", "--comb-detect=default --decomb=eedi2bob", "yadif=mode=send_frame:deint=interlaced"},
+		{"yadif", "--deinterlace=default", "yadif"},
+		{"bwdif", "--bwdif=default", "bwdif"},
+		{"bob", "--deinterlace=bob", "yadif=mode=send_field"},
+	}
+
+	plan := func(t *testing.T, transcoder string, deinterlacer string) string {
+		setFlag(t, "transcoder", transcoder)
+		setFlag(t, "deinterlacer", deinterlacer)
+		tasks := varchive.GenerateTasks([]*varchive.Group{catalogueTestGroup(t, directory, "")})
+		return varchive.RenderPlan(tasks, varchive.NewEstimator())
+	}
+
+	for _, test := range tests {
+		t.Run(test.deinterlacer, func(t *testing.T) {
+			assertEqual(t, "HandBrake", 2, strings.Count(plan(t, "handbrake", test.deinterlacer), " "+test.handBrake+" "))
+			assertEqual(t, "ffmpeg", 2, strings.Count(plan(t, "ffmpeg", test.deinterlacer), " -vf "+test.ffmpeg+" "))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_parseFfmpegEncoders(t *testing.T) {
+	output := `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D libx265              libx265 H.265 / HEVC (codec hevc)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+`
+
+	encoders := varchive.ParseFfmpegList(output)
+
+	assertEqual(t, "encoders", 4, len(encoders))
+	assertEqual(t, "libx265", true, encoders["libx265"])
+	assertEqual(t, "libmp3lame", true, encoders["libmp3lame"])
+	assertEqual(t, "legend", false, encoders["="])
+}
+
+func Test_parseFfmpegFilters(t *testing.T) {
+	output := `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+ ... apad              A->A       Pad audio with silence.
+ TSC hqdn3d            V->V       Apply a High Quality 3D Denoiser.
+ T.. idet              V->V       Interlace detect Filter.
+ ... loudnorm          A->A       EBU R128 loudness normalization
+`
+
+	filters := varchive.ParseFfmpegList(output)
+
+	assertEqual(t, "filters", 4, len(filters))
+	assertEqual(t, "idet", true, filters["idet"])
+	assertEqual(t, "nlmeans", false, filters["nlmeans"])
+}
+
+func Test_parseHandBrakeEncoders(t *testing.T) {
+	help := `### Video Options------------------------------------------------------------
+
+   -e, --encoder <string>  Select video encoder:
+                               svt_av1
+                               x264
+                               x264_10bit
+                               x265
+                               mpeg4
+   --encoder-preset <string>
+                           Adjust video encoding settings for a particular
+`
+
+	encoders := varchive.ParseHandBrakeEncoders(help)
+
+	assertEqual(t, "encoders", 5, len(encoders))
+	assertEqual(t, "x265", true, encoders["x265"])
+	assertEqual(t, "option", false, encoders["--encoder-preset"])
+}
+
+func Test_missingToolsAreKnownBeforeTheGroups(t *testing.T) {
+	parseTestArguments()
+	setFlag(t, "transcoder", "ffmpeg")
+	setFlag(t, "deinterlace", "never")
+
+	directory := t.TempDir()
+	setFlag(t, "ffmpeg", fakeTool(t, directory, "ffmpeg", "echo ' V....D libx265   libx265 H.265'"))
+	setFlag(t, "ffprobe", filepath.Join(directory, "no-ffprobe"))
+
+	chain, err := varchive.ParseFilterChain("denoise=nlmeans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	capabilities := varchive.ProbeCapabilities()
+
+	missing := capabilities.MissingTools()
+	assertEqual(t, "missing tools", 1, len(missing))
+	assertEqual(t, "ffprobe is missing", true, strings.HasPrefix(missing[0], "ffprobe ("))
+
+	unsupported := capabilities.Unsupported(nil, []varchive.FilterChain{chain})
+	assertEqual(t, "unsupported", 1, len(unsupported))
+	assertEqual(t, "the filter is missing", true, strings.HasPrefix(unsupported[0], "ffmpeg has no nlmeans filter"))
+
+	assertEqual(t, "problems are both", 2, len(capabilities.Problems(nil, []varchive.FilterChain{chain})))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_ffMetadataWithChapters(t *testing.T) {
+	metadata := varchive.NewFfMetadata()
+	metadata.AddTag("title", "Mum; Dad = #1")
+	metadata.AddChapter("sample 1", 0, 12.5)
+	metadata.AddChapter("sample 2", 12.5, 20.25)
+
+	expected := ";FFMETADATA1\n" +
+		"title=Mum\\; Dad \\= \\#1\n" +
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=12500\ntitle=sample 1\n" +
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=12500\nEND=20250\ntitle=sample 2\n"
+
+	assertEqual(t, "ffmetadata file", expected, metadata.String())
+}
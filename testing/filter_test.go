@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_filterByExtensionAndGlobs(t *testing.T) {
+	filter := varchive.NewFileFilter([]string{"tape*"}, []string{"*-draft.*"}, "mpg, .MOV")
+
+	tests := map[string]bool{
+		"/v/tape1.mpg":       true,
+		"/v/tape2.MOV":       true,
+		"/v/tape3.mpg.part":  false,
+		"/v/Thumbs.db":       false,
+		"/v/holiday.mpg":     false,
+		"/v/tape4-draft.mpg": false,
+	}
+
+	for path, accepted := range tests {
+		assertEqual(t, path, accepted, filter.Check(path) == "")
+	}
+}
+
+func Test_filterUsesIgnoreFile(t *testing.T) {
+	directory := t.TempDir()
+	rules := "# not these\n\n*blue screen*\n"
+	if err := os.WriteFile(filepath.Join(directory, ".varchiveignore"), []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := varchive.NewFileFilter(nil, nil, "all")
+
+	assertEqual(t, "ignored", true, filter.Check(filepath.Join(directory, "the blue screen.mpg")) != "")
+	assertEqual(t, "kept", "", filter.Check(filepath.Join(directory, "tape 1.mpg")))
+	assertEqual(t, "anything goes", "", filter.Check(filepath.Join(directory, "notes.txt")))
+}
+
+func Test_parseIgnoreRules(t *testing.T) {
+	rules := varchive.ParseIgnoreRules(strings.NewReader("  *.txt \n# comment\n\n.DS_Store\n"))
+	assertEqual(t, "rules", "*.txt|.DS_Store", strings.Join(rules, "|"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_filterChain(t *testing.T) {
+	chain, err := varchive.ParseFilterChain("denoise=nlmeans:light, deblock, sharpen=strong, rotate=90, color=bt709, range=tv, trim=4s-1m30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "normalised", "denoise=nlmeans:light,deblock=medium,sharpen=strong,rotate=90,colour=bt709,range=tv,trim=4s-1m30s",
+		chain.String())
+
+	assertEqual(t, "HandBrake",
+		"--nlmeans=light --deblock=medium --lapsharp=strong --rotate=angle=90:hflip=0 --colorspace=bt709 --color-range=limited",
+		strings.Join(chain.HandBrakeArgs(), " "))
+
+	assertEqual(t, "ffmpeg filters",
+		"nlmeans=s=2,deblock=filter=strong,unsharp=5:5:1.5,transpose=clock,colorspace=all=bt709,scale=out_range=tv",
+		strings.Join(chain.FfmpegFilters(), ","))
+
+	assertEqual(t, "trim", "4-90", chain.Trim().String())
+}
+
+// the same conversion (primaries, transfer and matrix) whichever does the transcoding
+func Test_filterChainColourspaces(t *testing.T) {
+	tests := []struct {
+		colour      string
+		colourspace string
+	}{
+		{"bt709", "bt709"},
+		{"bt601-525", "bt601-6-525"},
+		{"bt601-625", "bt601-6-625"},
+		{"bt2020", "bt2020"},
+	}
+
+	for _, test := range tests {
+		chain, err := varchive.ParseFilterChain("colour=" + test.colour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, test.colour+" HandBrake", "--colorspace="+test.colourspace, strings.Join(chain.HandBrakeArgs(), " "))
+		assertEqual(t, test.colour+" ffmpeg", "colorspace=all="+test.colourspace, strings.Join(chain.FfmpegFilters(), ","))
+	}
+}
+
+func Test_filterChainDefaults(t *testing.T) {
+	chain, err := varchive.ParseFilterChain("denoise,rotate=auto,trim=-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "normalised", "denoise=hqdn3d:medium,rotate=auto,trim=-20", chain.String())
+	assertEqual(t, "HandBrake", "--hqdn3d=medium", strings.Join(chain.HandBrakeArgs(), " "))
+	assertEqual(t, "trim", "-20", chain.Trim().String())
+	assertEqual(t, "ffmpeg filters", "hqdn3d=4", strings.Join(chain.FfmpegFilters(), ","))
+}
+
+func Test_filterChainErrors(t *testing.T) {
+	for _, spec := range []string{"blur", "denoise=fft3d", "deblock=extreme", "rotate=45", "colour=srgb", "trim=10s-5s", "trim=10s"} {
+		if _, err := varchive.ParseFilterChain(spec); err == nil {
+			t.Fatalf("expected '%s' to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_graphHasAnEdgePerDependency(t *testing.T) {
+	part1 := varchive.NewTask(varchive.Transcode, "a.mpg", "a.mp4", 1000)
+	part2 := varchive.NewTask(varchive.Transcode, "b.mpg", "b.mp4", 2000)
+	final := varchive.NewConcatenateTask("out/ab.mp4", []*varchive.Task{part1, part2})
+	part1.MarkAsCompleted()
+
+	tasks := []*varchive.Task{part1, part2, final}
+
+	dot := varchive.RenderDot(tasks)
+	mermaid := varchive.RenderMermaid(tasks)
+
+	for _, part := range []*varchive.Task{part1, part2} {
+		assertEqual(t, "dot edge", true, strings.Contains(dot, fmt.Sprintf("t%d -> t%d;", part.Id(), final.Id())))
+		assertEqual(t, "mermaid edge", true, strings.Contains(mermaid, fmt.Sprintf("t%d --> t%d", part.Id(), final.Id())))
+	}
+
+	assertEqual(t, "completed task", true, strings.Contains(mermaid, fmt.Sprintf("class t%d complete", part1.Id())))
+	assertEqual(t, "runnable task", true, strings.Contains(mermaid, fmt.Sprintf("class t%d runnable", part2.Id())))
+	assertEqual(t, "pending task", true, strings.Contains(mermaid, fmt.Sprintf("class t%d pending", final.Id())))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_histoKeysAreInNaturalOrder(t *testing.T) {
+	h := varchive.NewHisto()
+	for _, key := range []string{"1080", "720", "576", "720", "1080", "720"} {
+		h.Add(key)
+	}
+
+	assertEqual(t, "by key", "576|720|1080", strings.Join(h.Keys(), "|"))
+	assertEqual(t, "by count", "720|1080|576", strings.Join(h.KeysByCount(), "|"))
+	assertEqual(t, "rendered by key", "     1 @ 576\n     3 @ 720\n     2 @ 1080\n", h.String())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+const logHeaderLine = "=========================================================="
+
+func readLog(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func writeToLog(t *testing.T, path string, mode string, maxSize int64, keep int, lines ...string) {
+	sink, err := varchive.OpenLogSink(path, mode, maxSize, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if _, err := sink.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+}
+
+func Test_logModes(t *testing.T) {
+	tests := []struct {
+		mode            string
+		expectedCurrent []string
+		expectedOld     []string
+	}{
+		{"append", []string{"first run", "second run"}, nil},
+		{"truncate", []string{"second run"}, nil},
+		{"rotate", []string{"second run"}, []string{"first run"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "varchive.log")
+			writeToLog(t, path, test.mode, 0, 5, "first run")
+			writeToLog(t, path, test.mode, 0, 5, "second run")
+
+			current := readLog(t, path)
+			assertEqual(t, "a header for each run", 2*len(test.expectedCurrent), strings.Count(current, logHeaderLine))
+			for _, line := range test.expectedCurrent {
+				assertEqual(t, "current log has "+line, true, strings.Contains(current, line))
+			}
+			assertEqual(t, "current log has only its runs", !strings.Contains(current, "first run"), test.mode != "append")
+
+			if test.expectedOld == nil {
+				_, err := os.Stat(path + ".1")
+				assertEqual(t, "no old log", true, os.IsNotExist(err))
+			} else {
+				old := readLog(t, path+".1")
+				assertEqual(t, "old log has the first run", true, strings.Contains(old, test.expectedOld[0]))
+			}
+		})
+	}
+}
+
+func Test_logRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "varchive.log")
+	line := strings.Repeat("x", 600)
+
+	// the header alone is a few hundred bytes, so each line starts a new file
+	writeToLog(t, path, "rotate", 1000, 2, "one "+line, "two "+line, "three "+line, "four "+line)
+
+	current := readLog(t, path)
+	assertEqual(t, "current log starts with a header", true, strings.HasSuffix(strings.SplitN(current, "\n", 2)[0], logHeaderLine))
+	assertEqual(t, "current log has the last line", true, strings.Contains(current, "four "))
+	assertEqual(t, "previous log", true, strings.Contains(readLog(t, path+".1"), "three "))
+	assertEqual(t, "previous log has a header too", true, strings.Contains(readLog(t, path+".1"), logHeaderLine))
+	assertEqual(t, "oldest log kept", true, strings.Contains(readLog(t, path+".2"), "two "))
+	_, err := os.Stat(path + ".3")
+	assertEqual(t, "only two old logs are kept", true, os.IsNotExist(err))
+}
+
+func Test_logCarriesOnWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "varchive.log")
+
+	// log.1 can't be replaced when it is a directory with something in it
+	if err := os.MkdirAll(filepath.Join(path+".1", "in the way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 600)
+	writeToLog(t, path, "rotate", 1000, 1, "one "+line, "two "+line)
+
+	current := readLog(t, path)
+	assertEqual(t, "first line kept", true, strings.Contains(current, "one "))
+	assertEqual(t, "second line written after the failure", true, strings.Contains(current, "two "))
+	assertEqual(t, "failure noted", true, strings.Contains(current, "Could not rotate the log"))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_manifestFromJson(t *testing.T) {
+	json := `[
+		{"output": "one", "title": "The first", "sources": ["a.mpg", "b.mpg"]},
+		{"output": "two.mp4", "profile": "h264", "sources": ["/abs/c.mpg"]}
+	]`
+
+	entries, err := varchive.ParseManifestJson(strings.NewReader(json))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "entry count", 2, len(entries))
+	assertEqual(t, "first output", "one", entries[0].Output)
+	assertEqual(t, "first title", "The first", entries[0].Title)
+	assertEqual(t, "first sources", "a.mpg|b.mpg", strings.Join(entries[0].Sources, "|"))
+	assertEqual(t, "second profile", "h264", entries[1].Profile)
+}
+
+func Test_manifestFromCsvKeepsSourcesInOrder(t *testing.T) {
+	csv := "output,source,title,date\n" +
+		"one,z.mpg,The first,2001-02-03\n" +
+		"two,b.mpg,,\n" +
+		"one,a.mpg,,\n" +
+		"one,m.mpg,ignored title,\n"
+
+	entries, err := varchive.ParseManifestCsv(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "entry count", 2, len(entries))
+	assertEqual(t, "first output", "one", entries[0].Output)
+	assertEqual(t, "first title", "The first", entries[0].Title)
+	assertEqual(t, "first date", "2001-02-03", entries[0].Date)
+	assertEqual(t, "first sources", "z.mpg|a.mpg|m.mpg", strings.Join(entries[0].Sources, "|"))
+	assertEqual(t, "second sources", "b.mpg", strings.Join(entries[1].Sources, "|"))
+}
+
+func Test_manifestCsvNeedsOutputAndSourceColumns(t *testing.T) {
+	_, err := varchive.ParseManifestCsv(strings.NewReader("output,title\none,foo\n"))
+	if err == nil {
+		t.Fatal("expected an error for a manifest with no 'source' column")
+	}
+}
+
+func Test_manifestCsvChapterColumn(t *testing.T) {
+	csv := "output,source,chapter\n" +
+		"one,a.mpg,Arrival\n" +
+		"one,b.mpg,\n"
+
+	entries, err := varchive.ParseManifestCsv(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "chapters line up with sources", "Arrival|", strings.Join(entries[0].Chapters, "|"))
+}
+
+func Test_manifestFilters(t *testing.T) {
+	csv := "output,source,filters\n" +
+		"one,a.mpg,\"denoise,deblock=light\"\n" +
+		"one,b.mpg,\n"
+
+	entries, err := varchive.ParseManifestCsv(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "filters", "denoise,deblock=light", entries[0].Filters)
+}
+
+func Test_manifestOutputMustBeAFileName(t *testing.T) {
+	directory := t.TempDir()
+	manifest := filepath.Join(directory, "manifest.json")
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "source")
+
+	for _, output := range []string{"../escaped.mp4", "/tmp/absolute.mp4", "sub/dir.mp4", `sub\dir.mp4`, ".."} {
+		writeTestFile(t, manifest, `[{"output": "`+strings.ReplaceAll(output, `\`, `\\`)+`", "profile": "default", "sources": ["a.mpg"]}]`)
+		_, err := varchive.ReadManifest(manifest)
+		if err == nil || !strings.Contains(err.Error(), "without any directories") {
+			t.Fatalf("expected the output '%s' to be rejected, got %v", output, err)
+		}
+	}
+
+	writeTestFile(t, manifest, `[{"output": "holiday 1998.mp4", "profile": "default", "sources": ["a.mpg"]}]`)
+	if _, err := varchive.ReadManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -55,3 +55,36 @@ func Test_ffProbeOutputCapture(t *testing.T) {
 	}
 }
 
+func Test_mediaInfoFromFfProbeJson(t *testing.T) {
+	output := `{
+		"streams": [
+			{"index": 0, "codec_type": "audio", "codec_name": "mp2", "channels": 2, "sample_rate": "48000", "tags": {"language": "eng"}},
+			{"index": 1, "codec_type": "video", "codec_name": "mjpeg", "width": 300, "height": 300, "disposition": {"attached_pic": 1}},
+			{"index": 2, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080,
+			 "avg_frame_rate": "30000/1001", "field_order": "tt",
+			 "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}
+		],
+		"format": {"duration": "12.500000", "tags": {"CREATION_TIME": "2021-03-04T05:06:07.000000Z"}}
+	}`
+
+	info, err := varchive.ParseMediaInfo([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "duration", 12.5, info.Duration)
+	assertEqual(t, "creation time", "2021-03-04T05:06:07.000000Z", info.FindTag("creation_time"))
+
+	video := info.VideoStream()
+	if video == nil {
+		t.Fatal("no video stream found")
+	}
+	assertEqual(t, "video stream skips cover art", 2, video.Index)
+	assertEqual(t, "fps", "29.97", fmt.Sprintf("%.2f", video.Fps))
+	assertEqual(t, "rotation", -90.0, video.Rotation)
+
+	audio := info.StreamsOfType("audio")
+	assertEqual(t, "audio streams", 1, len(audio))
+	assertEqual(t, "audio language", "eng", audio[0].Language)
+	assertEqual(t, "sample rate", 48000, audio[0].SampleRate)
+}
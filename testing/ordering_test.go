@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_naturalOrdering(t *testing.T) {
+	names := []string{"sample 10.mpg", "sample 2.mpg", "sample 1.mpg", "sample 02b.mpg", "sample.mpg", "Sample 3.mpg"}
+
+	sort.Slice(names, func(i, j int) bool { return varchive.NaturalLess(names[i], names[j]) })
+
+	expected := "Sample 3.mpg|sample 1.mpg|sample 2.mpg|sample 02b.mpg|sample 10.mpg|sample.mpg"
+	assertEqual(t, "natural order", expected, strings.Join(names, "|"))
+}
+
+func Test_parseTimecode(t *testing.T) {
+	seconds, err := varchive.ParseTimecode("01:02:03:12", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "non drop-frame", 3723.48, seconds)
+
+	dropFrame := []struct {
+		timecode string
+		fps      float64
+		expected string
+	}{
+		{"00:00:10;15", 29.97, "10.511"},
+		{"00:01:00;02", 29.97, "60.060"},  // 00:00:59;29 is the frame before
+		{"00:10:00;00", 29.97, "600.000"}, // every tenth minute keeps its frame numbers
+		{"01:00:00;00", 29.97, "3600.000"},
+		{"01:00:00.00", 59.94, "3600.000"},
+	}
+	for _, test := range dropFrame {
+		seconds, err := varchive.ParseTimecode(test.timecode, test.fps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "drop-frame "+test.timecode, test.expected, fmt.Sprintf("%.3f", seconds))
+	}
+
+	seconds, err = varchive.ParseTimecode("00:00:59;29", 29.97)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "the frame before a dropped one", "60.027", fmt.Sprintf("%.3f", seconds))
+
+	_, err = varchive.ParseTimecode("10:15", 25)
+	if err == nil {
+		t.Fatal("expected an error for a short timecode")
+	}
+}
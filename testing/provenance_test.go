@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func sha256Of(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_provenance(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+	writeTestFile(t, filepath.Join(directory, "out.mp4"), "archive")
+
+	group := catalogueTestGroup(t, directory, "deblock")
+	part1 := varchive.NewTask(varchive.Transcode, "a.mpg", "a.mp4", 5)
+	part2 := varchive.NewTask(varchive.Transcode, "b.mpg", "b.mp4", 6)
+	final := varchive.NewGroupConcatenateTask(group, []*varchive.Task{part1, part2})
+
+	provenance := varchive.NewProvenance(final)
+
+	assertEqual(t, "output", "out.mp4", provenance.Output)
+	assertEqual(t, "output size", int64(7), provenance.OutputSize)
+	assertEqual(t, "output checksum", sha256Of("archive"), provenance.OutputChecksum)
+	assertEqual(t, "profile", true, strings.HasPrefix(provenance.Profile, "default ("))
+	assertEqual(t, "settings record the filters", true, strings.Contains(provenance.Settings, "filters=deblock"))
+
+	// whatever the tools said, or why they couldn't say it
+	for _, tool := range []string{"HandBrakeCLI", "ffmpeg", "ffprobe"} {
+		_, found := provenance.Tools[tool]
+		assertEqual(t, "version of "+tool, true, found)
+	}
+
+	assertEqual(t, "sources", 2, len(provenance.Sources))
+	assertEqual(t, "first source", filepath.Join(directory, "a.mpg"), provenance.Sources[0].Path)
+	assertEqual(t, "first source checksum", sha256Of("first"), provenance.Sources[0].Checksum)
+	assertEqual(t, "second source checksum", sha256Of("second"), provenance.Sources[1].Checksum)
+	assertEqual(t, "second source size", int64(6), provenance.Sources[1].Size)
+
+	// dependees first, and the final task knows where its parts came from
+	assertEqual(t, "tasks", 3, len(provenance.Tasks))
+	assertEqual(t, "first task", part1.Id(), provenance.Tasks[0].Id)
+	assertEqual(t, "final task", final.Id(), provenance.Tasks[2].Id)
+	assertEqual(t, "final task type", "Concatenate", provenance.Tasks[2].Type)
+	assertEqual(t, "final task dependencies", fmt.Sprint([]int{part1.Id(), part2.Id()}), fmt.Sprint(provenance.Tasks[2].DependsOn))
+
+	content, err := json.Marshal(provenance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"outputSha256":`, `"settings":`, `"tools":`, `"sha256":"` + sha256Of("first") + `"`, `"dependsOn":`} {
+		assertEqual(t, "serialised "+key, true, strings.Contains(string(content), key))
+	}
+}
@@ -1,23 +1,166 @@
 package main
 
 import (
-	"reflect"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+
 	"davidhancock.com/varchive"
 )
 
-func TestScanPaths(t *testing.T) {
+// a directory with a bit of everything in it
+func scannerTestDirectory(t *testing.T) string {
+	directory := t.TempDir()
+
+	for _, name := range []string{"a.mpg", ".hidden.mpg", "notes.txt", "locked.mpg"} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	elsewhere := t.TempDir()
+	links := map[string]string{
+		"link.mpg":     filepath.Join(directory, "a.mpg"),
+		"dangling.mpg": filepath.Join(directory, "nowhere.mpg"),
+		"loop1.mpg":    filepath.Join(directory, "loop2.mpg"),
+		"loop2.mpg":    filepath.Join(directory, "loop1.mpg"),
+		"elsewhere":    elsewhere,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(directory, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// (root can read it anyway)
+	if err := os.Chmod(filepath.Join(directory, "locked.mpg"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	return directory
+}
+
+func scannedNames(files varchive.FilesWithSize) string {
+	names := []string{}
+	for _, file := range files {
+		names = append(names, filepath.Base(file.Path()))
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func Test_scanPaths(t *testing.T) {
+	directory := scannerTestDirectory(t)
+	locked := os.Geteuid() != 0
+
 	tests := []struct {
-		name string
-		want map[string]varchive.FilesWithSize
+		name    string
+		options varchive.ScanOptions
+		files   string
+		reasons map[string]string // by file name, just the start of the reason
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "defaults",
+			options: varchive.ScanOptions{},
+			files:   "a.mpg",
+			reasons: map[string]string{
+				".hidden.mpg":  "hidden",
+				"link.mpg":     "symbolic link",
+				"dangling.mpg": "symbolic link",
+				"loop1.mpg":    "symbolic link",
+				"elsewhere":    "symbolic link",
+			},
+		},
+		{
+			name:    "hidden files",
+			options: varchive.ScanOptions{IncludeHidden: true},
+			files:   ".hidden.mpg a.mpg",
+			reasons: map[string]string{"link.mpg": "symbolic link"},
+		},
+		{
+			name:    "following links",
+			options: varchive.ScanOptions{FollowSymlinks: true},
+			files:   "a.mpg",
+			reasons: map[string]string{
+				".hidden.mpg":  "hidden",
+				"link.mpg":     "same file as",
+				"dangling.mpg": "error:",
+				"loop1.mpg":    "error:",
+				"loop2.mpg":    "error:",
+				"elsewhere":    "symbolic link to a directory",
+			},
+		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := varchive.ScanPaths(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ScanPaths() = %v, want %v", got, tt.want)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.Filter = varchive.NewFileFilter(nil, nil, "mpg")
+			pathsAndFiles, report := varchive.ScanPathsWith([]string{directory}, test.options)
+			reasons := report.Reasons()
+
+			expected := test.files
+			if !locked {
+				expected += " locked.mpg"
+			}
+			assertEqual(t, test.name+" files", sortedWords(expected), scannedNames(pathsAndFiles[directory]))
+
+			for name, reason := range test.reasons {
+				got := reasons[filepath.Join(directory, name)]
+				if !strings.HasPrefix(got, reason) {
+					t.Errorf("%s: %s was left out for '%s', expected '%s...'", test.name, name, got, reason)
+				}
+			}
+			if _, found := reasons[filepath.Join(directory, "notes.txt")]; !found {
+				t.Errorf("%s: notes.txt should have been filtered out", test.name)
+			}
+			if locked && !strings.HasPrefix(reasons[filepath.Join(directory, "locked.mpg")], "error:") {
+				t.Errorf("%s: locked.mpg should not be readable", test.name)
 			}
 		})
 	}
 }
+
+func Test_scanSingleFile(t *testing.T) {
+	directory := scannerTestDirectory(t)
+
+	// asked for by name, so neither hidden nor filtered
+	for _, name := range []string{".hidden.mpg", "notes.txt"} {
+		path := filepath.Join(directory, name)
+		pathsAndFiles, report := varchive.ScanPathsWith([]string{path}, varchive.ScanOptions{Filter: varchive.NewFileFilter(nil, nil, "mpg")})
+
+		assertEqual(t, name, name, scannedNames(pathsAndFiles[path]))
+		assertEqual(t, name+" report", true, report.IsEmpty())
+	}
+}
+
+func sortedWords(text string) string {
+	words := strings.Fields(text)
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+func Test_scanSkipsHiddenDirectories(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"a.mpg", ".hidden.mpg", ".AppleDouble/._a.mpg", ".Trashes/501/b.mpg", "@eaDir/a.mpg@SynoEAStream"} {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// no filter, and still no hidden files
+	pathsAndFiles, report := varchive.ScanPathsWith([]string{directory}, varchive.ScanOptions{})
+	reasons := report.Reasons()
+
+	assertEqual(t, "files", "a.mpg", scannedNames(pathsAndFiles[directory]))
+	assertEqual(t, "hidden file", "hidden", reasons[filepath.Join(directory, ".hidden.mpg")])
+	for _, name := range []string{".AppleDouble", ".Trashes", "@eaDir"} {
+		assertEqual(t, name, "hidden directory", reasons[filepath.Join(directory, name)])
+	}
+	assertEqual(t, "nothing reported from inside them", 4, len(reasons))
+}
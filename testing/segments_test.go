@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_parseSegments(t *testing.T) {
+	segments, err := varchive.ParseSegments("# the blue screen, then the good bits\n0:04-1:30; 2m-1:02:03.5\n4000-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, "segment count", 3, len(segments))
+	assertEqual(t, "first", "4-90", segments[0].String())
+	assertEqual(t, "second", "120-3723.5", segments[1].String())
+	assertEqual(t, "third", "4000-", segments[2].String())
+
+	assertEqual(t, "HandBrake", "--start-at seconds:120 --stop-at seconds:3603.5", strings.Join(segments[1].HandBrakeArgs(), " "))
+	assertEqual(t, "ffmpeg", "-ss 120 -to 3723.5", strings.Join(segments[1].FfmpegInputArgs(), " "))
+}
+
+func Test_parseSegmentsErrors(t *testing.T) {
+	for _, text := range []string{"4s", "10-5", "1:xx-", "0-20, 10-30", "20-, 30-40"} {
+		if _, err := varchive.ParseSegments(text); err == nil {
+			t.Fatalf("expected '%s' to be rejected", text)
+		}
+	}
+}
+
+func Test_segmentsWithin(t *testing.T) {
+	segments, err := varchive.ParseSegments("0:04-1:30, 2m-3m, 10m-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept, dropped := varchive.SegmentsWithin(segments, 150)
+	assertEqual(t, "kept", 2, len(kept))
+	assertEqual(t, "dropped", 1, len(dropped))
+	assertEqual(t, "dropped segment", "600-", dropped[0].String())
+	assertEqual(t, "parts are numbered afresh", "2 of 2", kept[1].Part())
+
+	kept, dropped = varchive.SegmentsWithin(segments, 120)
+	assertEqual(t, "a segment starting at the end is dropped", 2, len(dropped))
+	assertEqual(t, "a lone segment is not a part", "", kept[0].Part())
+
+	kept, _ = varchive.SegmentsWithin(segments, 4)
+	assertEqual(t, "nothing left", 0, len(kept))
+}
+
+func Test_suggestTrim(t *testing.T) {
+	output := `[blackdetect @ 0x1] black_start:0 black_end:4.2 black_duration:4.2
+[silencedetect @ 0x2] silence_start: 1800.5
+[silencedetect @ 0x2] silence_end: 1803 | silence_duration: 2.5
+[blackdetect @ 0x1] black_start:3590.04 black_end:3600 black_duration:9.96
+[silencedetect @ 0x2] silence_start: 3595
+`
+
+	assertEqual(t, "black at both ends", "4.2-3590.04", varchive.SuggestTrim(output, 3600).String())
+
+	// tape noise isn't black, but it might be quiet
+	output = `[silencedetect @ 0x2] silence_start: 3595.5
+`
+	assertEqual(t, "silence at the end", "-3595.5", varchive.SuggestTrim(output, 3600).String())
+
+	if suggestion := varchive.SuggestTrim("", 3600); suggestion != nil {
+		t.Fatalf("expected no suggestion, got %s", suggestion)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+func probed(t *testing.T, ffprobeOutput string) varchive.MediaInfo {
+	info, err := varchive.ParseMediaInfo([]byte(ffprobeOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func Test_sourceTagsFrom(t *testing.T) {
+	info := probed(t, `{
+		"format": {"duration": "10.0", "tags": {"creation_time": "2019-06-01T10:00:00Z", "encoder": "Lavf58", "com.apple.quicktime.make": "Apple"}},
+		"streams": [
+			{"index": 0, "codec_type": "video", "tags": {"rotate": "90", "handler_name": "Core Media Video"}},
+			{"index": 1, "codec_type": "audio", "tags": {"location": "+51.5-000.1/"}}
+		]
+	}`)
+
+	tags := varchive.SourceTagsFrom("a.mov", info)
+
+	assertEqual(t, "number of tags kept", 3, len(tags.Tags))
+	assertEqual(t, "container tag", "2019-06-01T10:00:00Z", tags.Tags["creation_time"])
+	assertEqual(t, "apple tag", "Apple", tags.Tags["com.apple.quicktime.make"])
+	assertEqual(t, "stream tag", "+51.5-000.1/", tags.Tags["location"])
+	assertEqual(t, "encoder is not kept", "", tags.Tags["encoder"])
+	assertEqual(t, "rotation", 90.0, tags.Rotation)
+}
+
+func sourceTags(path string, tags map[string]string, rotation float64) *varchive.SourceTags {
+	return &varchive.SourceTags{Path: path, Tags: tags, Rotation: rotation, NotEmbedded: map[string]string{}}
+}
+
+func Test_embedSourceTags(t *testing.T) {
+	tests := []struct {
+		name             string
+		commandLine      map[string]string
+		sources          []*varchive.SourceTags
+		expectedMetadata string
+		expectedLeftOver []map[string]string
+		expectedSidecar  bool
+	}{
+		{
+			name: "everything agrees",
+			sources: []*varchive.SourceTags{
+				sourceTags("a.mov", map[string]string{"make": "Apple", "model": "iPhone"}, 0),
+				sourceTags("b.mov", map[string]string{"make": "Apple"}, 0),
+			},
+			expectedMetadata: ";FFMETADATA1\nmake=Apple\nmodel=iPhone\n",
+			expectedLeftOver: []map[string]string{{}, {}},
+			expectedSidecar:  false,
+		},
+		{
+			name: "the first source wins",
+			sources: []*varchive.SourceTags{
+				sourceTags("a.mov", map[string]string{"creation_time": "2019"}, 0),
+				sourceTags("b.mov", map[string]string{"creation_time": "2020", "model": "iPhone"}, 0),
+			},
+			expectedMetadata: ";FFMETADATA1\ncreation_time=2019\nmodel=iPhone\n",
+			expectedLeftOver: []map[string]string{{}, {"creation_time": "2020"}},
+			expectedSidecar:  true,
+		},
+		{
+			name:        "the command line wins",
+			commandLine: map[string]string{"make": "Canon"},
+			sources: []*varchive.SourceTags{
+				sourceTags("a.mov", map[string]string{"make": "Apple"}, 0),
+			},
+			expectedMetadata: ";FFMETADATA1\nmake=Canon\n",
+			expectedLeftOver: []map[string]string{{"make": "Apple"}},
+			expectedSidecar:  true,
+		},
+		{
+			name: "rotation is never embedded",
+			sources: []*varchive.SourceTags{
+				sourceTags("a.mov", map[string]string{}, -90),
+			},
+			expectedMetadata: ";FFMETADATA1\n",
+			expectedLeftOver: []map[string]string{{"rotation": "-90 (applied during transcode)"}},
+			expectedSidecar:  true,
+		},
+		{
+			name:             "no sources",
+			sources:          []*varchive.SourceTags{},
+			expectedMetadata: ";FFMETADATA1\n",
+			expectedLeftOver: []map[string]string{},
+			expectedSidecar:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := varchive.NewFfMetadata()
+			for key, value := range test.commandLine {
+				metadata.AddTag(key, value)
+			}
+
+			varchive.EmbedSourceTags(metadata, test.sources)
+
+			assertEqual(t, "metadata", test.expectedMetadata, metadata.String())
+			for index, source := range test.sources {
+				assertEqual(t, "left over from "+source.Path, fmt.Sprint(test.expectedLeftOver[index]), fmt.Sprint(source.NotEmbedded))
+			}
+			assertEqual(t, "needs a sidecar", test.expectedSidecar, varchive.NeedsSourceTagsSidecar(test.sources))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+// audio first, then video, then two more audio tracks (the second of which is the default)
+const multiTrackProbe = `{
+	"streams": [
+		{"index": 0, "codec_type": "audio", "codec_name": "ac3", "tags": {"language": "fre"}},
+		{"index": 1, "codec_type": "video", "codec_name": "mpeg2video"},
+		{"index": 2, "codec_type": "audio", "codec_name": "ac3", "tags": {"language": "eng"}},
+		{"index": 3, "codec_type": "audio", "codec_name": "mp2", "disposition": {"default": 1}}
+	],
+	"format": {}
+}`
+
+func Test_selectStreams(t *testing.T) {
+	media, err := varchive.ParseMediaInfo([]byte(multiTrackProbe))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		language string
+		all      bool
+		expected string
+	}{
+		{"", false, "1 [3]"},    // the default track
+		{"eng", false, "1 [2]"}, // by language
+		{"ger", false, "1 [3]"}, // no such language, so the default
+		{"", true, "1 [0 2 3]"}, // everything
+	}
+
+	for _, test := range tests {
+		selection, err := varchive.SelectStreams(media, test.language, test.all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := fmt.Sprintf("%d %v", selection.Video, selection.Audio)
+		assertEqual(t, fmt.Sprintf("language '%s', all %v", test.language, test.all), test.expected, actual)
+	}
+}
+
+func Test_selectStreamsWithNoAudio(t *testing.T) {
+	media, err := varchive.ParseMediaInfo([]byte(`{"streams": [{"index": 0, "codec_type": "video"}], "format": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selection, err := varchive.SelectStreams(media, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "no audio", 0, len(selection.Audio))
+}
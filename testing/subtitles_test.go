@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"davidhancock.com/varchive"
+)
+
+const sampleSrt = "1\r\n00:00:01,500 --> 00:00:04,000\r\nHello\r\n\r\n" +
+	"2\r\n00:00:09,000 --> 00:00:12,250\r\nTwo\r\nlines\r\n\r\n" +
+	"3\r\n00:01:00,000 --> 00:01:02,000\r\nLater\r\n"
+
+func Test_parseSrt(t *testing.T) {
+	cues := varchive.ParseSrt(sampleSrt)
+
+	assertEqual(t, "cues", 3, len(cues))
+	assertEqual(t, "start", 1.5, cues[0].Start)
+	assertEqual(t, "end", 12.25, cues[1].End)
+	assertEqual(t, "text", "Two\nlines", cues[1].Text)
+	assertEqual(t, "later", 60.0, cues[2].Start)
+}
+
+func Test_clipCues(t *testing.T) {
+	cues := varchive.ParseSrt(sampleSrt)
+
+	// the first cue is cut down, the last is outside the segment
+	clipped := varchive.ClipCues(cues, &varchive.Segment{Start: 2, End: 30})
+
+	assertEqual(t, "cues", 2, len(clipped))
+	assertEqual(t, "start", 0.0, clipped[0].Start)
+	assertEqual(t, "end", 2.0, clipped[0].End)
+	assertEqual(t, "moved", 7.0, clipped[1].Start)
+}
+
+func Test_mergeSubtitles(t *testing.T) {
+	first := []*varchive.Cue{{Start: 1, End: 2, Text: "one"}, {Start: 9, End: 12, Text: "runs over"}}
+	second := []*varchive.Cue{{Start: 0.5, End: 1.5, Text: "two"}}
+
+	merged := varchive.MergeSubtitles([][]*varchive.Cue{first, {}, second}, []float64{10, 5, 20})
+
+	assertEqual(t, "cues", 3, len(merged))
+	assertEqual(t, "cut short", 10.0, merged[1].End)
+	assertEqual(t, "offset", 15.5, merged[2].Start)
+
+	assertEqual(t, "srt", "1\n00:00:01,000 --> 00:00:02,000\none\n\n"+
+		"2\n00:00:09,000 --> 00:00:10,000\nruns over\n\n"+
+		"3\n00:00:15,500 --> 00:00:16,500\ntwo\n\n", varchive.FormatSrt(merged))
+
+	assertEqual(t, "vtt", "WEBVTT\n\n00:00:15.500 --> 00:00:16.500\ntwo\n\n", varchive.FormatVtt(merged[2:]))
+}
+
+func Test_keptSubtitlesInAnMp4(t *testing.T) {
+	parseTestArguments()
+	setFlag(t, "useCatalogue", "false")
+	setFlag(t, "transcoder", "ffmpeg")
+	setFlag(t, "subtitles", "keep")
+
+	directory := t.TempDir()
+	t.Chdir(directory)
+	writeTestFile(t, filepath.Join(directory, "a.mpg"), "first")
+	writeTestFile(t, filepath.Join(directory, "b.mpg"), "second")
+
+	tools := t.TempDir()
+	setFlag(t, "ffprobe", fakeTool(t, tools, "ffprobe", `echo '{"format": {"duration": "10"}, "streams": [`+
+		`{"index": 0, "codec_type": "video", "codec_name": "mpeg2video"},`+
+		`{"index": 1, "codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle"},`+
+		`{"index": 2, "codec_type": "subtitle", "codec_name": "dvd_subtitle"},`+
+		`{"index": 3, "codec_type": "subtitle", "codec_name": "subrip"}]}'`))
+	setFlag(t, "ffmpeg", fakeTool(t, tools, "ffmpeg", "exit 0"))
+
+	tasks := varchive.GenerateTasks([]*varchive.Group{catalogueTestGroup(t, directory, "")})
+	plan := varchive.RenderPlan(tasks, varchive.NewEstimator())
+
+	assertEqual(t, "the DVD bitmaps are copied", true, strings.Contains(plan, "-map 0:s:1 -c:s:0 copy"))
+	assertEqual(t, "the text is converted", true, strings.Contains(plan, "-map 0:s:2 -c:s:1 mov_text"))
+	assertEqual(t, "the Blu-ray bitmaps are left out", false, strings.Contains(plan, "0:s:0"))
+	assertEqual(t, "no catch all", false, strings.Contains(plan, "0:s?"))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"davidhancock.com/varchive"
+)
+
+func Test_expandTagTemplate(t *testing.T) {
+	data := &varchive.TagData{Title: "Holiday", Date: "1998-08-01", Profile: "default", Version: "1.2.3"}
+
+	value, err := varchive.ExpandTagTemplate("{{.Title}} ({{.Date}}) by varchive {{.Version}}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "expanded template", "Holiday (1998-08-01) by varchive 1.2.3", value)
+
+	value, err = varchive.ExpandTagTemplate("  {{.Description}} ", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "empty values are trimmed away", "", value)
+}
+
+func Test_sourceDate(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		tags     string
+		expected string
+	}{
+		{"creation_time", `{"creation_time": "1998-08-01T10:30:00.000000Z"}`, "1998-08-01"},
+		{"older ffmpeg", `{"creation_time": "1998-08-01 10:30:00"}`, "1998-08-01"},
+		{"no creation_time", `{"encoder": "Lavf58"}`, "2021-03-04"},
+		{"unreadable creation_time", `{"creation_time": "last summer"}`, "2021-03-04"},
+	}
+
+	for _, test := range tests {
+		info := probed(t, `{"format": {"duration": "10.0", "tags": `+test.tags+`}, "streams": []}`)
+		assertEqual(t, test.name, test.expected, varchive.SourceDate(info, modTime).Format("2006-01-02"))
+	}
+}
@@ -1,6 +1,9 @@
 package varchive
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,15 +12,25 @@ import (
 	"path/filepath"
 	"os/exec"
 	"strings"
+	"sync"
 )
 
 func fatal(message string) {
 
-	fmt.Println(message)
+	fmt.Fprintln(consoleWriter(), message)
 	os.Exit(1)
 }
 
 func invoke(command string, args []string) string {
+	output, err := tryInvoke(command, args)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return output
+}
+
+// like invoke, but for when there is something else to try, so a failure is handed back
+func tryInvoke(command string, args []string) (string, error) {
 
 	if settings.verbose {
 		Log("Invoking: %s %s", command, strings.Join(args, ` `))
@@ -28,24 +41,25 @@ func invoke(command string, args []string) string {
 		stderr, err := command.StderrPipe()
 
 		if err != nil {
-			fatal(err.Error())
+			return "", err
 		}
 
 		if err := command.Start(); err != nil {
-			fatal(err.Error())
+			return "", err
 		}
 
 		slurp, _ := io.ReadAll(stderr)
 		//fmt.Printf("%s\n", slurp)
 
 		if err := command.Wait(); err != nil {
-			fatal(err.Error())
+			return string(slurp), err
 		}
 	
-		return string(slurp)
+		return string(slurp), nil
 	}
-	return ""
+	return "", nil
 }
+
 	/*if err == nil {
 			if settings.verbose {
 				Log("Return ok, stdout: %v", string(output))
@@ -60,7 +74,30 @@ func invoke(command string, args []string) string {
 	//return ""
 
 
+// like invoke, but for tools that only read files (ffprobe and friends), so it still
+// runs in dry run mode, and it hands back the output and any error rather than giving up
+func probe(command string, args []string) (string, string, error) {
+
+	if settings.verbose {
+		Log("Probing: %s %s", command, strings.Join(args, ` `))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		err = fmt.Errorf("%s failed: %s", command, err.Error())
+	}
+	return stdout.String(), stderr.String(), err
+}
+
 func createOutputRootIfRequired() {
+	if settings.dryRun {
+		return
+	}
 	if _, err := os.Stat(settings.outputRoot); err != nil {
 		if os.IsNotExist(err) {
 			os.Mkdir(settings.outputRoot, 0755)
@@ -68,10 +105,14 @@ func createOutputRootIfRequired() {
 	}
 }
 
-func failIfConcatenationFileAlreadyExists(path string) {
-	if _, err := os.Stat(path); err == nil {
-		fatal(fmt.Sprintf("%s exists, will not overwrite", path))
+// for the lists of known values, e.g. the -order modes
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
 	}
+	return false
 }
 
 func lastBitOfPath(path string) string {
@@ -85,7 +126,15 @@ func sanitisePath(path string) string {
 	return path
 }
 
+var plannedTemporaryFiles = 0
+
+// in dry run mode nothing is created, we just need a plausible name for the plan
 func makeTemporaryFile(extension string) string {
+	if settings.dryRun {
+		plannedTemporaryFiles++
+		return filepath.Join(os.TempDir(), fmt.Sprintf("varchive.planned-%d%s", plannedTemporaryFiles, extension))
+	}
+
 	file, err := ioutil.TempFile("", "varchive.*"+extension)
 	if err != nil {
 		fatal(err.Error())
@@ -99,6 +148,45 @@ func removeTemporaryFile(path string) {
 	os.Remove(path)
 }
 
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+var checksums = make(map[string]string)
+var checksumsLock sync.Mutex
+
+// source files get checksummed for more than one reason, so remember the answers
+// (by size and modification time as well, in case the file changes under us)
+func sourceChecksum(file *FileWithSize) (string, error) {
+	key := fmt.Sprintf("%s|%d|%d", file.path, file.size, file.modTime.UnixNano())
+
+	checksumsLock.Lock()
+	checksum, found := checksums[key]
+	checksumsLock.Unlock()
+
+	if found {
+		return checksum, nil
+	}
+
+	checksum, err := fileChecksum(file.path)
+	if err == nil {
+		checksumsLock.Lock()
+		checksums[key] = checksum
+		checksumsLock.Unlock()
+	}
+	return checksum, err
+}
+
 func getFileExtension(path string) string {
 	return filepath.Ext(path)
 }